@@ -0,0 +1,116 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "net/url"
+    "os"
+    "time"
+    "github.com/jackc/pgx/v5"
+    "golang.org/x/crypto/bcrypt"
+)
+
+const (
+    tokenPurposeReset = "reset"
+    tokenPurposeVerify = "verify"
+    resetTokenTTL = time.Hour
+    verifyTokenTTL = 48 * time.Hour
+)
+
+func appURL() string {
+    if u := os.Getenv("APP_URL"); u != "" { return u }
+    return "http://localhost:8080"
+}
+
+// createAuthToken stores a single-use token for purpose and returns the raw value.
+// Older unused tokens for the same purpose are invalidated.
+func (s *Server) createAuthToken(ctx context.Context, email, purpose string, ttl time.Duration) (string, error) {
+    tok := randomToken(32)
+    if _, err := s.pool.Exec(ctx, "UPDATE auth_tokens SET used_at=now() WHERE user_email=$1 AND purpose=$2 AND used_at IS NULL", email, purpose); err != nil { return "", err }
+    if _, err := s.pool.Exec(ctx, "INSERT INTO auth_tokens (token_hash, user_email, purpose, expires_at) VALUES ($1,$2,$3,$4)", hashToken(tok), email, purpose, time.Now().Add(ttl)); err != nil { return "", err }
+    return tok, nil
+}
+
+// consumeAuthToken marks a token used and returns its owner, or pgx.ErrNoRows
+// if it is unknown, expired or already used.
+func consumeAuthToken(ctx context.Context, tx pgx.Tx, tok, purpose string) (string, error) {
+    var email string
+    err := tx.QueryRow(ctx, "UPDATE auth_tokens SET used_at=now() WHERE token_hash=$1 AND purpose=$2 AND used_at IS NULL AND expires_at > now() RETURNING user_email", hashToken(tok), purpose).Scan(&email)
+    return email, err
+}
+
+func (s *Server) sendVerificationEmail(ctx context.Context, email string) error {
+    tok, err := s.createAuthToken(ctx, email, tokenPurposeVerify, verifyTokenTTL)
+    if err != nil { return err }
+    link := appURL() + "/auth?verify=" + url.QueryEscape(tok)
+    s.notifier.SendEmail(email, Notification{ Event: "account.verify_email", Subject: "Confirme seu email", Text: "Confirme seu email acessando o link abaixo (válido por 48 horas):\n\n" + link })
+    return nil
+}
+
+// handleForgotPassword always answers 200 so it can't be used to probe for accounts.
+func (s *Server) handleForgotPassword(w http.ResponseWriter, r *http.Request) {
+    var body struct{ Email string }
+    _ = json.NewDecoder(r.Body).Decode(&body)
+    if body.Email == "" { jsonResp(w, 400, map[string]string{"error":"invalid_input"}); return }
+    var email string
+    err := s.pool.QueryRow(r.Context(), "SELECT email FROM users WHERE email=$1", body.Email).Scan(&email)
+    if err != nil && !errors.Is(err, pgx.ErrNoRows) { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    if err == nil {
+        tok, err := s.createAuthToken(r.Context(), email, tokenPurposeReset, resetTokenTTL)
+        if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+        link := appURL() + "/auth?reset=" + url.QueryEscape(tok)
+        s.notifier.SendEmail(email, Notification{ Event: "account.password_reset", Subject: "Redefinição de senha", Text: "Recebemos um pedido para redefinir sua senha. Acesse o link abaixo (válido por 1 hora):\n\n" + link + "\n\nSe não foi você, ignore este email." })
+    }
+    jsonResp(w, 200, map[string]bool{"success": true})
+}
+
+// handleResetPassword sets a new password and revokes every existing session.
+// Receiving the reset link also proves ownership of the email.
+func (s *Server) handleResetPassword(w http.ResponseWriter, r *http.Request) {
+    var body struct{ Token, Password string }
+    _ = json.NewDecoder(r.Body).Decode(&body)
+    if body.Token == "" || body.Password == "" { jsonResp(w, 400, map[string]string{"error":"invalid_input"}); return }
+    tx, err := s.pool.Begin(r.Context())
+    if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    defer tx.Rollback(r.Context())
+    email, err := consumeAuthToken(r.Context(), tx, body.Token, tokenPurposeReset)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) { jsonResp(w, 400, map[string]string{"error":"invalid_token"}); return }
+        jsonResp(w, 500, map[string]string{"error": err.Error()}); return
+    }
+    hash, _ := bcrypt.GenerateFromPassword([]byte(body.Password), 10)
+    if _, err := tx.Exec(r.Context(), "UPDATE users SET password_hash=$2, email_verified=TRUE WHERE email=$1", email, string(hash)); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    if _, err := tx.Exec(r.Context(), "UPDATE sessions SET revoked_at=now() WHERE user_email=$1 AND revoked_at IS NULL", email); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    if err := tx.Commit(r.Context()); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    s.audit(r, auditEntry{ Actor: email, Action: "auth.password_reset", TargetType: "user", TargetID: email })
+    jsonResp(w, 200, map[string]bool{"success": true})
+}
+
+func (s *Server) handleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+    var body struct{ Token string }
+    _ = json.NewDecoder(r.Body).Decode(&body)
+    if body.Token == "" { jsonResp(w, 400, map[string]string{"error":"invalid_input"}); return }
+    tx, err := s.pool.Begin(r.Context())
+    if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    defer tx.Rollback(r.Context())
+    email, err := consumeAuthToken(r.Context(), tx, body.Token, tokenPurposeVerify)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) { jsonResp(w, 400, map[string]string{"error":"invalid_token"}); return }
+        jsonResp(w, 500, map[string]string{"error": err.Error()}); return
+    }
+    if _, err := tx.Exec(r.Context(), "UPDATE users SET email_verified=TRUE WHERE email=$1", email); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    if err := tx.Commit(r.Context()); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    s.audit(r, auditEntry{ Actor: email, Action: "user.verify_email", TargetType: "user", TargetID: email })
+    jsonResp(w, 200, map[string]bool{"success": true})
+}
+
+func (s *Server) handleResendVerification(w http.ResponseWriter, r *http.Request) {
+    c := getClaims(r)
+    var verified bool
+    if err := s.pool.QueryRow(r.Context(), "SELECT email_verified FROM users WHERE email=$1", c["email"]).Scan(&verified); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    if verified { jsonResp(w, 200, map[string]bool{"success": true}); return }
+    if err := s.sendVerificationEmail(r.Context(), c["email"].(string)); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    jsonResp(w, 200, map[string]bool{"success": true})
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+    "context"
+    "crypto/subtle"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+    "github.com/golang-jwt/jwt/v5"
+    "github.com/gorilla/mux"
+    "github.com/jackc/pgx/v5"
+)
+
+const apiKeyPrefix = "key_"
+
+var apiKeyScopes = map[string]bool{
+    "bookings:read": true,
+    "bookings:write": true,
+    "blocks:read": true,
+    "blocks:write": true,
+    "ical:read": true,
+    "ical:write": true,
+    "messages:read": true,
+    "messages:write": true,
+    "stats:read": true,
+}
+
+var errInvalidAPIKey = errors.New("invalid_api_key")
+
+// authenticateAPIKey resolves "key_<lookup>_<secret>" into claims for the key's
+// owner. Keys are looked up by their public part and compared by hash.
+func (s *Server) authenticateAPIKey(ctx context.Context, key string) (jwt.MapClaims, error) {
+    lookup, secret, ok := strings.Cut(strings.TrimPrefix(key, apiKeyPrefix), "_")
+    if !ok || lookup == "" || secret == "" { return nil, errInvalidAPIKey }
+    var id int64; var hash, email string; var scopes []string; var isOwner bool
+    err := s.pool.QueryRow(ctx, "SELECT k.id, k.key_hash, k.owner_email, k.scopes, COALESCE(u.is_owner,false) FROM api_keys k JOIN users u ON u.email = k.owner_email WHERE k.lookup=$1 AND k.revoked_at IS NULL AND (k.expires_at IS NULL OR k.expires_at > now())", lookup).Scan(&id, &hash, &email, &scopes, &isOwner)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) { return nil, errInvalidAPIKey }
+        return nil, err
+    }
+    if subtle.ConstantTimeCompare([]byte(hash), []byte(hashToken(secret))) != 1 { return nil, errInvalidAPIKey }
+    _, _ = s.pool.Exec(ctx, "UPDATE api_keys SET last_used_at=now() WHERE id=$1 AND (last_used_at IS NULL OR last_used_at < now() - interval '1 minute')", id)
+    return jwt.MapClaims{"email": email, "is_owner": isOwner, "api_key_id": id, "scopes": scopes}, nil
+}
+
+func hasScopes(granted []string, required []string) bool {
+    for _, req := range required {
+        found := false
+        for _, g := range granted { if g == req { found = true; break } }
+        if !found { return false }
+    }
+    return true
+}
+
+func (s *Server) handleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+    if !s.requireOwner(w, r) { return }
+    var body struct{ Name string; Scopes []string; ExpiresAt *time.Time `json:"expires_at"` }
+    _ = json.NewDecoder(r.Body).Decode(&body)
+    if body.Name == "" || len(body.Scopes) == 0 { jsonResp(w, 400, map[string]string{"error":"invalid_input"}); return }
+    for _, sc := range body.Scopes { if !apiKeyScopes[sc] { jsonResp(w, 400, map[string]string{"error":"invalid_scope"}); return } }
+    if body.ExpiresAt != nil && body.ExpiresAt.Before(time.Now()) { jsonResp(w, 400, map[string]string{"error":"invalid_expiry"}); return }
+    lookup, secret := strings.ReplaceAll(randomToken(9), "_", "-"), randomToken(32)
+    var id int64
+    if err := s.pool.QueryRow(r.Context(), "INSERT INTO api_keys (name, lookup, key_hash, owner_email, scopes, expires_at) VALUES ($1,$2,$3,$4,$5,$6) RETURNING id", body.Name, lookup, hashToken(secret), getClaims(r)["email"], body.Scopes, body.ExpiresAt).Scan(&id); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    s.audit(r, auditEntry{ Action: "api_key.create", TargetType: "api_key", TargetID: strconv.FormatInt(id, 10), After: map[string]any{"name": body.Name, "scopes": body.Scopes, "expires_at": body.ExpiresAt} })
+    // The full key is only shown here; only its hash is stored.
+    jsonResp(w, 200, map[string]any{"id": id, "key": apiKeyPrefix + lookup + "_" + secret})
+}
+
+func (s *Server) handleListAPIKeys(w http.ResponseWriter, r *http.Request) {
+    if !s.requireOwner(w, r) { return }
+    rows, err := s.pool.Query(r.Context(), "SELECT id, name, lookup, scopes, expires_at, last_used_at, revoked_at, created_at FROM api_keys WHERE owner_email=$1 ORDER BY created_at DESC", getClaims(r)["email"])
+    if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    type rec struct{ ID int64 `json:"id"`; Name string `json:"name"`; Prefix string `json:"prefix"`; Scopes []string `json:"scopes"`; ExpiresAt *time.Time `json:"expires_at"`; LastUsedAt *time.Time `json:"last_used_at"`; RevokedAt *time.Time `json:"revoked_at"`; CreatedAt time.Time `json:"created_at"` }
+    var out []rec
+    for rows.Next() { var a rec; var lookup string; if err := rows.Scan(&a.ID,&a.Name,&lookup,&a.Scopes,&a.ExpiresAt,&a.LastUsedAt,&a.RevokedAt,&a.CreatedAt); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return } ; a.Prefix = apiKeyPrefix + lookup; out = append(out,a) }
+    if rows.Err() != nil { jsonResp(w, 500, map[string]string{"error": rows.Err().Error()}); return }
+    jsonResp(w, 200, map[string]any{"data": out})
+}
+
+func (s *Server) handleRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+    if !s.requireOwner(w, r) { return }
+    tag, err := s.pool.Exec(r.Context(), "UPDATE api_keys SET revoked_at=now() WHERE id=$1 AND owner_email=$2 AND revoked_at IS NULL", mux.Vars(r)["id"], getClaims(r)["email"])
+    if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    if tag.RowsAffected() == 0 { jsonResp(w, 404, map[string]string{"error":"not_found"}); return }
+    s.audit(r, auditEntry{ Action: "api_key.revoke", TargetType: "api_key", TargetID: mux.Vars(r)["id"] })
+    jsonResp(w, 200, map[string]bool{"success": true})
+}
+
+var errInvalidAppPassword = errors.New("invalid_app_password")
+
+// authenticateAppPassword checks a Basic auth pair from a calendar app against
+// the user's active app passwords.
+func (s *Server) authenticateAppPassword(ctx context.Context, email, password string) (jwt.MapClaims, error) {
+    if email == "" || password == "" { return nil, errInvalidAppPassword }
+    rows, err := s.pool.Query(ctx, "SELECT p.id, p.password_hash, COALESCE(u.is_owner,false) FROM app_passwords p JOIN users u ON u.email = p.owner_email WHERE p.owner_email=$1 AND p.revoked_at IS NULL", email)
+    if err != nil { return nil, err }
+    defer rows.Close()
+    hash := hashToken(password)
+    for rows.Next() {
+        var id int64; var stored string; var isOwner bool
+        if err := rows.Scan(&id, &stored, &isOwner); err != nil { return nil, err }
+        if subtle.ConstantTimeCompare([]byte(stored), []byte(hash)) != 1 { continue }
+        rows.Close()
+        _, _ = s.pool.Exec(ctx, "UPDATE app_passwords SET last_used_at=now() WHERE id=$1 AND (last_used_at IS NULL OR last_used_at < now() - interval '1 minute')", id)
+        return jwt.MapClaims{"email": email, "is_owner": isOwner, "app_password_id": id}, nil
+    }
+    if rows.Err() != nil { return nil, rows.Err() }
+    return nil, errInvalidAppPassword
+}
+
+func (s *Server) handleCreateAppPassword(w http.ResponseWriter, r *http.Request) {
+    if !s.requireOwner(w, r) { return }
+    var body struct{ Name string }
+    _ = json.NewDecoder(r.Body).Decode(&body)
+    if body.Name == "" { jsonResp(w, 400, map[string]string{"error":"invalid_input"}); return }
+    password := randomToken(18)
+    var id int64
+    if err := s.pool.QueryRow(r.Context(), "INSERT INTO app_passwords (owner_email, name, password_hash) VALUES ($1,$2,$3) RETURNING id", getClaims(r)["email"], body.Name, hashToken(password)).Scan(&id); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    s.audit(r, auditEntry{ Action: "app_password.create", TargetType: "app_password", TargetID: strconv.FormatInt(id, 10), After: map[string]any{"name": body.Name} })
+    // Like API keys, the password is only shown once.
+    jsonResp(w, 200, map[string]any{"id": id, "username": getClaims(r)["email"], "password": password, "caldav_path": "/caldav/"})
+}
+
+func (s *Server) handleListAppPasswords(w http.ResponseWriter, r *http.Request) {
+    if !s.requireOwner(w, r) { return }
+    rows, err := s.pool.Query(r.Context(), "SELECT id, name, last_used_at, revoked_at, created_at FROM app_passwords WHERE owner_email=$1 ORDER BY created_at DESC", getClaims(r)["email"])
+    if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    type rec struct{ ID int64 `json:"id"`; Name string `json:"name"`; LastUsedAt *time.Time `json:"last_used_at"`; RevokedAt *time.Time `json:"revoked_at"`; CreatedAt time.Time `json:"created_at"` }
+    var out []rec
+    for rows.Next() { var a rec; if err := rows.Scan(&a.ID,&a.Name,&a.LastUsedAt,&a.RevokedAt,&a.CreatedAt); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return } ; out = append(out,a) }
+    if rows.Err() != nil { jsonResp(w, 500, map[string]string{"error": rows.Err().Error()}); return }
+    jsonResp(w, 200, map[string]any{"data": out})
+}
+
+func (s *Server) handleRevokeAppPassword(w http.ResponseWriter, r *http.Request) {
+    if !s.requireOwner(w, r) { return }
+    tag, err := s.pool.Exec(r.Context(), "UPDATE app_passwords SET revoked_at=now() WHERE id=$1 AND owner_email=$2 AND revoked_at IS NULL", mux.Vars(r)["id"], getClaims(r)["email"])
+    if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    if tag.RowsAffected() == 0 { jsonResp(w, 404, map[string]string{"error":"not_found"}); return }
+    s.audit(r, auditEntry{ Action: "app_password.revoke", TargetType: "app_password", TargetID: mux.Vars(r)["id"] })
+    jsonResp(w, 200, map[string]bool{"success": true})
+}
@@ -0,0 +1,16 @@
+package main
+
+import "testing"
+
+func TestHasScopes(t *testing.T) {
+    tests := []struct{ granted, required []string; want bool }{
+        {[]string{"blocks:read"}, nil, true},
+        {[]string{"blocks:read", "bookings:read"}, []string{"bookings:read"}, true},
+        {[]string{"blocks:read"}, []string{"blocks:read", "blocks:write"}, false},
+        {nil, []string{"blocks:read"}, false},
+        {[]string{"blocks:readwrite"}, []string{"blocks:read"}, false},
+    }
+    for _, tt := range tests {
+        if got := hasScopes(tt.granted, tt.required); got != tt.want { t.Errorf("hasScopes(%v, %v) = %v, want %v", tt.granted, tt.required, got, tt.want) }
+    }
+}
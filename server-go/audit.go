@@ -0,0 +1,158 @@
+package main
+
+import (
+    "context"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "log"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+    "github.com/jackc/pgx/v5"
+)
+
+// loadAuditKey reads AUDIT_PSEUDONYM_KEY, the HMAC key for the pseudonyms
+// that stand in for people in audit_events. Like the JWT secret it is
+// required in production and falls back to a development key elsewhere.
+func loadAuditKey() ([]byte, error) {
+    key := os.Getenv("AUDIT_PSEUDONYM_KEY")
+    if key == "" {
+        if isProduction() { return nil, errors.New("AUDIT_PSEUDONYM_KEY must be set in production") }
+        log.Println("AVISO: AUDIT_PSEUDONYM_KEY não definido, usando chave de desenvolvimento")
+        return []byte("dev-audit-key"), nil
+    }
+    if isProduction() && len(key) < minJWTSecretLen { return nil, errors.New("AUDIT_PSEUDONYM_KEY is too weak for production") }
+    return []byte(key), nil
+}
+
+// auditSubject is the pseudonym recorded for an email: stable, so an
+// owner can still filter by person, but not reversible without the key.
+func auditSubject(key []byte, email string) string {
+    mac := hmac.New(sha256.New, key)
+    mac.Write([]byte(strings.ToLower(strings.TrimSpace(email))))
+    return "sub_" + hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// redactAuditSubject removes a data subject's personal data from the log
+// through the audit_redact_subject function, the only way events change.
+// Events from before pseudonyms carry the raw email, so both are matched.
+func (s *Server) redactAuditSubject(ctx context.Context, tx pgx.Tx, email string) (int64, error) {
+    var n int64
+    err := tx.QueryRow(ctx, "SELECT audit_redact_subject($1)", []string{auditSubject(s.auditKey, email), email}).Scan(&n)
+    return n, err
+}
+
+// auditEntry describes one mutation. Actor defaults to the authenticated
+// caller. Emails given as Actor, or as TargetID of a "user" target, are stored
+// as pseudonyms.
+type auditEntry struct {
+    Actor string
+    Action string
+    TargetType string
+    TargetID string
+    Before any
+    After any
+}
+
+func auditJSON(v any) []byte {
+    if v == nil { return nil }
+    b, err := json.Marshal(v)
+    if err != nil { return nil }
+    return b
+}
+
+// audit appends to audit_events. Failures are logged rather than failing the
+// request, since the mutation has already happened.
+func (s *Server) audit(r *http.Request, e auditEntry) {
+    c := getClaims(r)
+    actorType := "user"
+    if e.Actor == "" { e.Actor, _ = c["email"].(string) }
+    if _, ok := c["api_key_id"]; ok { actorType = "api_key" }
+    if _, ok := c["app_password_id"]; ok { actorType = "app_password" }
+    if e.Actor == "" { actorType = "anonymous" }
+    s.writeAudit(r.Context(), e, actorType, clientIP(r), r.UserAgent())
+}
+
+// auditSystem records actions taken by background jobs.
+func (s *Server) auditSystem(ctx context.Context, e auditEntry) {
+    if e.Actor == "" { e.Actor = "system" }
+    s.writeAudit(ctx, e, "system", "", "")
+}
+
+func (s *Server) writeAudit(ctx context.Context, e auditEntry, actorType, ip, ua string) {
+    if actorType != "system" && e.Actor != "" { e.Actor = auditSubject(s.auditKey, e.Actor) }
+    if e.TargetType == "user" && e.TargetID != "" { e.TargetID = auditSubject(s.auditKey, e.TargetID) }
+    _, err := s.pool.Exec(ctx, "INSERT INTO audit_events (actor, actor_type, action, target_type, target_id, before, after, ip, user_agent) VALUES (NULLIF($1,''),$2,$3,NULLIF($4,''),NULLIF($5,''),$6,$7,NULLIF($8,''),NULLIF($9,''))", e.Actor, actorType, e.Action, e.TargetType, e.TargetID, auditJSON(e.Before), auditJSON(e.After), ip, ua)
+    if err != nil { log.Printf("audit %s: %v", e.Action, err) }
+}
+
+// handleListAudit returns events newest first. Filters: actor, action,
+// target_type, target_id, from, to (RFC3339), before_id and limit. Emails
+// given as actor or target_id are matched through their pseudonym, and
+// pseudonyms of current users are shown alongside their email.
+func (s *Server) handleListAudit(w http.ResponseWriter, r *http.Request) {
+    if !s.requireOwner(w, r) { return }
+    q := r.URL.Query()
+    sql := "SELECT id, COALESCE(actor,''), actor_type, action, COALESCE(target_type,''), COALESCE(target_id,''), COALESCE(before::text,'null'), COALESCE(after::text,'null'), COALESCE(ip,''), COALESCE(user_agent,''), created_at FROM audit_events WHERE true"
+    var args []any
+    add := func(cond string, v any) { args = append(args, v); sql += " AND " + cond + "$" + strconv.Itoa(len(args)) }
+    for _, f := range []string{"actor", "action", "target_type", "target_id"} {
+        v := q.Get(f)
+        if (f == "actor" || f == "target_id") && strings.Contains(v, "@") { v = auditSubject(s.auditKey, v) }
+        if v != "" { add(f+"=", v) }
+    }
+    for _, f := range []struct{ param, cond string }{{"from", "created_at >= "}, {"to", "created_at < "}} {
+        if v := q.Get(f.param); v != "" {
+            t, err := time.Parse(time.RFC3339, v)
+            if err != nil { jsonResp(w, 400, map[string]string{"error":"invalid_" + f.param}); return }
+            add(f.cond, t)
+        }
+    }
+    if v := q.Get("before_id"); v != "" {
+        id, err := strconv.ParseInt(v, 10, 64)
+        if err != nil { jsonResp(w, 400, map[string]string{"error":"invalid_before_id"}); return }
+        add("id < ", id)
+    }
+    limit := 100
+    if v, err := strconv.Atoi(q.Get("limit")); err == nil && v > 0 && v <= 500 { limit = v }
+    sql += " ORDER BY id DESC LIMIT " + strconv.Itoa(limit)
+    rows, err := s.pool.Query(r.Context(), sql, args...)
+    if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    type rec struct{ ID int64 `json:"id"`; Actor string `json:"actor"`; ActorEmail string `json:"actor_email,omitempty"`; TargetEmail string `json:"target_email,omitempty"`; ActorType string `json:"actor_type"`; Action string `json:"action"`; TargetType string `json:"target_type"`; TargetID string `json:"target_id"`; Before json.RawMessage `json:"before"`; After json.RawMessage `json:"after"`; IP string `json:"ip"`; UserAgent string `json:"user_agent"`; CreatedAt time.Time `json:"created_at"` }
+    var out []rec
+    for rows.Next() {
+        var a rec; var before, after string
+        if err := rows.Scan(&a.ID,&a.Actor,&a.ActorType,&a.Action,&a.TargetType,&a.TargetID,&before,&after,&a.IP,&a.UserAgent,&a.CreatedAt); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+        a.Before, a.After = json.RawMessage(before), json.RawMessage(after)
+        out = append(out,a)
+    }
+    if rows.Err() != nil { jsonResp(w, 500, map[string]string{"error": rows.Err().Error()}); return }
+    emails, err := s.auditSubjectEmails(r.Context())
+    if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    for i := range out {
+        out[i].ActorEmail = emails[out[i].Actor]
+        if out[i].TargetType == "user" { out[i].TargetEmail = emails[out[i].TargetID] }
+    }
+    jsonResp(w, 200, map[string]any{"data": out})
+}
+
+// auditSubjectEmails maps the pseudonyms of existing users back to their
+// email for display. Erased users are no longer in users, so they stay
+// pseudonymous.
+func (s *Server) auditSubjectEmails(ctx context.Context) (map[string]string, error) {
+    rows, err := s.pool.Query(ctx, "SELECT email FROM users")
+    if err != nil { return nil, err }
+    defer rows.Close()
+    out := map[string]string{}
+    for rows.Next() {
+        var email string
+        if err := rows.Scan(&email); err != nil { return nil, err }
+        out[auditSubject(s.auditKey, email)] = email
+    }
+    return out, rows.Err()
+}
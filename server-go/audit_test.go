@@ -0,0 +1,25 @@
+package main
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestAuditSubject(t *testing.T) {
+    key := []byte("test-audit-key")
+    a := auditSubject(key, "Guest@Example.com")
+    tests := []struct {
+        name string
+        got string
+        same bool
+    }{
+        {"stable", auditSubject(key, "Guest@Example.com"), true},
+        {"case and space insensitive", auditSubject(key, "  guest@example.com "), true},
+        {"other email", auditSubject(key, "other@example.com"), false},
+        {"other key", auditSubject([]byte("another-key"), "Guest@Example.com"), false},
+    }
+    for _, tt := range tests {
+        if (tt.got == a) != tt.same { t.Errorf("%s: %q vs %q, same=%v", tt.name, tt.got, a, tt.same) }
+    }
+    if !strings.HasPrefix(a, "sub_") || len(a) != 36 || strings.Contains(a, "guest") { t.Errorf("unexpected pseudonym %q", a) }
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "math"
+    "net/http"
+    "os"
+    "sort"
+    "sync"
+    "time"
+    "github.com/jackc/pgx/v5"
+)
+
+// PricingPolicy is the nightly rate card, stored in app_settings key 'pricing'.
+// Weekdays use Go's numbering (0 = Sunday).
+type PricingPolicy struct {
+    Currency string `json:"currency"`
+    BasePrice float64 `json:"base_price"`
+    WeekendPrice float64 `json:"weekend_price"`
+    WeekendDays []int `json:"weekend_days"`
+    MinNights int `json:"min_nights"`
+    NoArrivalDays []int `json:"no_arrival_days"`
+    NoDepartureDays []int `json:"no_departure_days"`
+}
+
+// defaultPricing matches the prices the booking page has always shown.
+var defaultPricing = PricingPolicy{ Currency: "BRL", BasePrice: 5000, WeekendPrice: 6000, WeekendDays: []int{5, 6}, MinNights: 1, NoArrivalDays: []int{}, NoDepartureDays: []int{} }
+
+func (s *Server) pricingPolicy(ctx context.Context) (PricingPolicy, error) {
+    p := defaultPricing
+    var raw []byte
+    err := s.pool.QueryRow(ctx, "SELECT value FROM app_settings WHERE key='pricing'").Scan(&raw)
+    if errors.Is(err, pgx.ErrNoRows) { return p, nil }
+    if err != nil { return p, err }
+    return p, json.Unmarshal(raw, &p)
+}
+
+func weekdayIn(days []int, d time.Time) bool {
+    for _, x := range days { if x == int(d.Weekday()) { return true } }
+    return false
+}
+
+// stayRestriction returns the error code for a stay the rate card doesn't
+// allow, or "".
+func stayRestriction(p PricingPolicy, checkIn, checkOut time.Time) string {
+    if nights := int(checkOut.Sub(checkIn).Hours()/24 + 0.5); nights < p.MinNights { return "min_nights" }
+    if weekdayIn(p.NoArrivalDays, checkIn) { return "closed_to_arrival" }
+    if weekdayIn(p.NoDepartureDays, checkOut) { return "closed_to_departure" }
+    return ""
+}
+
+// stayQuote is what a stay costs, with the long-stay discount the booking
+// page shows: 3% from 7 nights, 5% from 28.
+type stayQuote struct {
+    Nights int
+    Subtotal float64
+    Discount float64
+    Total float64
+}
+
+func quoteStay(p PricingPolicy, checkIn, checkOut time.Time) stayQuote {
+    var q stayQuote
+    for d := checkIn; d.Before(checkOut); d = d.AddDate(0, 0, 1) {
+        q.Nights++
+        if weekdayIn(p.WeekendDays, d) { q.Subtotal += p.WeekendPrice } else { q.Subtotal += p.BasePrice }
+    }
+    rate := 0.0
+    if q.Nights >= 28 { rate = 0.05 } else if q.Nights >= 7 { rate = 0.03 }
+    q.Discount = q.Subtotal * rate
+    q.Total = math.Round(q.Subtotal - q.Discount)
+    return q
+}
+
+type dateRange struct {
+    From string `json:"from"`
+    To string `json:"to"`
+}
+
+type nightInfo struct {
+    Date string `json:"date"`
+    Available bool `json:"available"`
+    Price float64 `json:"price"`
+    MinNights int `json:"min_nights"`
+    ClosedToArrival bool `json:"closed_to_arrival"`
+    ClosedToDeparture bool `json:"closed_to_departure"`
+}
+
+type availabilityResponse struct {
+    From string `json:"from"`
+    To string `json:"to"`
+    Currency string `json:"currency"`
+    Unavailable []dateRange `json:"unavailable"`
+    Nights []nightInfo `json:"nights"`
+}
+
+// availabilityCache keeps computed availability and free/busy results. Local
+// changes and feed syncs clear it; the TTL bounds anything else.
+type availabilityCache struct {
+    mu sync.Mutex
+    ttl time.Duration
+    entries map[string]availabilityCacheEntry
+}
+
+type availabilityCacheEntry struct {
+    value any
+    expires time.Time
+}
+
+func newAvailabilityCache() *availabilityCache {
+    ttl := 5 * time.Minute
+    if d, err := time.ParseDuration(os.Getenv("AVAILABILITY_CACHE_TTL")); err == nil && d >= 0 { ttl = d }
+    return &availabilityCache{ ttl: ttl, entries: map[string]availabilityCacheEntry{} }
+}
+
+func (c *availabilityCache) get(key string) any {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    e, ok := c.entries[key]
+    if !ok || time.Now().After(e.expires) { return nil }
+    return e.value
+}
+
+func (c *availabilityCache) put(key string, value any) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if len(c.entries) >= 256 { c.entries = map[string]availabilityCacheEntry{} }
+    c.entries[key] = availabilityCacheEntry{ value: value, expires: time.Now().Add(c.ttl) }
+}
+
+func (c *availabilityCache) clear() {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.entries = map[string]availabilityCacheEntry{}
+}
+
+// invalidateAvailability is called after anything that changes which nights are taken or their price.
+func (s *Server) invalidateAvailability() { s.availability.clear() }
+
+// mergeRanges clips [start, end) ranges to the window and collapses overlaps
+// and adjacent ranges.
+func mergeRanges(events []icsEvent, from, to time.Time) []icsEvent {
+    var rs []icsEvent
+    for _, e := range events {
+        if !e.End.After(from) || !e.Start.Before(to) { continue }
+        r := icsEvent{ Start: e.Start, End: e.End }
+        if r.Start.Before(from) { r.Start = from }
+        if r.End.After(to) { r.End = to }
+        rs = append(rs, r)
+    }
+    sort.Slice(rs, func(i, j int) bool { return rs[i].Start.Before(rs[j].Start) })
+    var out []icsEvent
+    for _, r := range rs {
+        if n := len(out); n > 0 && !r.Start.After(out[n-1].End) {
+            if r.End.After(out[n-1].End) { out[n-1].End = r.End }
+            continue
+        }
+        out = append(out, r)
+    }
+    return out
+}
+
+func (s *Server) computeAvailability(ctx context.Context, from, to time.Time) (*availabilityResponse, error) {
+    p, err := s.pricingPolicy(ctx)
+    if err != nil { return nil, err }
+    t, err := s.turnoverPolicy(ctx)
+    if err != nil { return nil, err }
+    events, err := s.collectCalendarEvents(ctx, 0)
+    if err != nil { return nil, err }
+    busy := mergeRanges(expandEvents(events, from, to), from, to)
+    resp := &availabilityResponse{ From: from.Format(dateLayout), To: to.Format(dateLayout), Currency: p.Currency, Unavailable: []dateRange{}, Nights: []nightInfo{} }
+    for _, b := range busy { resp.Unavailable = append(resp.Unavailable, dateRange{ From: b.Start.Format(dateLayout), To: b.End.Format(dateLayout) }) }
+    i := 0
+    for d := from; d.Before(to); d = d.AddDate(0, 0, 1) {
+        for i < len(busy) && !busy[i].End.After(d) { i++ }
+        n := nightInfo{ Date: d.Format(dateLayout), Available: i >= len(busy) || d.Before(busy[i].Start), Price: p.BasePrice, MinNights: p.MinNights }
+        if weekdayIn(p.WeekendDays, d) { n.Price = p.WeekendPrice }
+        n.ClosedToArrival = weekdayIn(p.NoArrivalDays, d)
+        n.ClosedToDeparture = weekdayIn(p.NoDepartureDays, d)
+        resp.Nights = append(resp.Nights, n)
+    }
+    applyTurnover(resp, t, events, from, to)
+    return resp, nil
+}
+
+const maxAvailabilityDays = 366
+
+// availabilityWindow reads ?from= and ?to= as dates. from defaults to today
+// at the property and to to 180 days later. A non-empty string is the error
+// code to return.
+func availabilityWindow(r *http.Request) (time.Time, time.Time, string) {
+    q := r.URL.Query()
+    from := dateIn(time.Now())
+    if v := q.Get("from"); v != "" {
+        d, err := time.Parse(dateLayout, v)
+        if err != nil { return from, from, "invalid_from" }
+        from = d
+    }
+    to := from.AddDate(0, 0, 180)
+    if v := q.Get("to"); v != "" {
+        d, err := time.Parse(dateLayout, v)
+        if err != nil { return from, to, "invalid_to" }
+        to = d
+    }
+    if !to.After(from) || to.Sub(from) > maxAvailabilityDays*24*time.Hour { return from, to, "invalid_range" }
+    return from, to, ""
+}
+
+// writeFeedsStale answers 503 while imported feeds are too stale to say
+// which nights are free: showing them as free could sell a night twice.
+func writeFeedsStale(w http.ResponseWriter) {
+    w.Header().Set("Retry-After", "60")
+    jsonResp(w, http.StatusServiceUnavailable, map[string]string{"error": errFeedsStale.Error()})
+}
+
+// handleAvailability is public: it tells guests which nights are free and
+// what they cost, with no guest or source details.
+func (s *Server) handleAvailability(w http.ResponseWriter, r *http.Request) {
+    from, to, code := availabilityWindow(r)
+    if code != "" { jsonResp(w, 400, map[string]string{"error": code}); return }
+    if err := s.checkFeedsFresh(r.Context()); err != nil {
+        if errors.Is(err, errFeedsStale) { writeFeedsStale(w); return }
+        jsonResp(w, 500, map[string]string{"error": err.Error()}); return
+    }
+    key := "availability|" + from.Format(dateLayout) + "|" + to.Format(dateLayout)
+    resp, _ := s.availability.get(key).(*availabilityResponse)
+    if resp == nil {
+        var err error
+        resp, err = s.computeAvailability(r.Context(), from, to)
+        if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+        s.availability.put(key, resp)
+    }
+    w.Header().Set("Cache-Control", "public, max-age=60")
+    jsonResp(w, 200, resp)
+}
+
+func (s *Server) handleGetPricing(w http.ResponseWriter, r *http.Request) {
+    if !s.requireOwner(w, r) { return }
+    p, err := s.pricingPolicy(r.Context())
+    if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    jsonResp(w, 200, p)
+}
+
+func validWeekdays(days []int) bool {
+    for _, d := range days { if d < 0 || d > 6 { return false } }
+    return true
+}
+
+func (s *Server) handlePutPricing(w http.ResponseWriter, r *http.Request) {
+    if !s.requireOwner(w, r) { return }
+    p := defaultPricing
+    if err := json.NewDecoder(r.Body).Decode(&p); err != nil { jsonResp(w, 400, map[string]string{"error":"invalid_input"}); return }
+    if p.Currency == "" || p.BasePrice < 0 || p.WeekendPrice < 0 || p.MinNights < 1 || !validWeekdays(p.WeekendDays) || !validWeekdays(p.NoArrivalDays) || !validWeekdays(p.NoDepartureDays) { jsonResp(w, 400, map[string]string{"error":"invalid_input"}); return }
+    before, err := s.pricingPolicy(r.Context())
+    if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    raw, _ := json.Marshal(p)
+    if _, err := s.pool.Exec(r.Context(), "INSERT INTO app_settings (key, value) VALUES ('pricing', $1) ON CONFLICT (key) DO UPDATE SET value=EXCLUDED.value, updated_at=now()", raw); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    s.invalidateAvailability()
+    s.audit(r, auditEntry{ Action: "settings.pricing", TargetType: "settings", TargetID: "pricing", Before: before, After: p })
+    jsonResp(w, 200, p)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+    "testing"
+    "time"
+)
+
+func mustDate(t *testing.T, s string) time.Time {
+    t.Helper()
+    d, err := time.Parse(dateLayout, s)
+    if err != nil { t.Fatal(err) }
+    return d
+}
+
+// spans turns "2026-01-10/2026-01-12" pairs into date ranges.
+func spans(t *testing.T, rs ...string) []icsEvent {
+    t.Helper()
+    var out []icsEvent
+    for _, r := range rs { out = append(out, icsEvent{ Start: mustDate(t, r[:10]), End: mustDate(t, r[11:]) }) }
+    return out
+}
+
+func formatSpans(rs []icsEvent) []string {
+    var out []string
+    for _, r := range rs { out = append(out, r.Start.Format(dateLayout)+"/"+r.End.Format(dateLayout)) }
+    return out
+}
+
+func sameSpans(a, b []string) bool {
+    if len(a) != len(b) { return false }
+    for i := range a { if a[i] != b[i] { return false } }
+    return true
+}
+
+func TestMergeRanges(t *testing.T) {
+    tests := []struct {
+        name string
+        in []string
+        want []string
+    }{
+        {"empty", nil, nil},
+        {"disjoint stay sorted", []string{"2026-01-20/2026-01-22", "2026-01-10/2026-01-12"}, []string{"2026-01-10/2026-01-12", "2026-01-20/2026-01-22"}},
+        {"overlapping", []string{"2026-01-10/2026-01-15", "2026-01-12/2026-01-18"}, []string{"2026-01-10/2026-01-18"}},
+        {"adjacent", []string{"2026-01-10/2026-01-12", "2026-01-12/2026-01-14"}, []string{"2026-01-10/2026-01-14"}},
+        {"contained", []string{"2026-01-10/2026-01-20", "2026-01-12/2026-01-14"}, []string{"2026-01-10/2026-01-20"}},
+        {"clipped to window", []string{"2025-12-20/2026-01-03", "2026-01-30/2026-02-10"}, []string{"2026-01-01/2026-01-03", "2026-01-30/2026-02-01"}},
+        {"outside window", []string{"2025-12-20/2026-01-01", "2026-02-01/2026-02-03"}, nil},
+    }
+    from, to := mustDate(t, "2026-01-01"), mustDate(t, "2026-02-01")
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got := formatSpans(mergeRanges(spans(t, tt.in...), from, to))
+            if !sameSpans(got, tt.want) { t.Errorf("mergeRanges = %v, want %v", got, tt.want) }
+        })
+    }
+}
+
+func TestStayRestriction(t *testing.T) {
+    p := PricingPolicy{ MinNights: 2, NoArrivalDays: []int{0}, NoDepartureDays: []int{6} }
+    tests := []struct{ in, out, want string }{
+        {"2026-01-05", "2026-01-07", ""},
+        {"2026-01-05", "2026-01-06", "min_nights"},
+        {"2026-01-04", "2026-01-07", "closed_to_arrival"},
+        {"2026-01-07", "2026-01-10", "closed_to_departure"},
+        {"2026-01-07", "2026-01-11", ""},
+    }
+    for _, tt := range tests {
+        if got := stayRestriction(p, mustDate(t, tt.in), mustDate(t, tt.out)); got != tt.want { t.Errorf("stayRestriction(%s, %s) = %q, want %q", tt.in, tt.out, got, tt.want) }
+    }
+}
+
+func TestQuoteStay(t *testing.T) {
+    tests := []struct{ in, out string; want stayQuote }{
+        {"2026-01-05", "2026-01-07", stayQuote{ Nights: 2, Subtotal: 10000, Total: 10000 }},
+        {"2026-01-08", "2026-01-11", stayQuote{ Nights: 3, Subtotal: 17000, Total: 17000 }},
+        {"2026-01-05", "2026-01-12", stayQuote{ Nights: 7, Subtotal: 37000, Discount: 1110, Total: 35890 }},
+        {"2026-01-05", "2026-02-02", stayQuote{ Nights: 28, Subtotal: 148000, Discount: 7400, Total: 140600 }},
+    }
+    for _, tt := range tests {
+        if got := quoteStay(defaultPricing, mustDate(t, tt.in), mustDate(t, tt.out)); got != tt.want { t.Errorf("quoteStay(%s, %s) = %+v, want %+v", tt.in, tt.out, got, tt.want) }
+    }
+}
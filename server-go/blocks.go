@@ -0,0 +1,227 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "io"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+    "github.com/jackc/pgx/v5"
+)
+
+// blockRow is a blocks row as the calendars see it. UID and Name are only set
+// for blocks created from a calendar app over CalDAV. Recurring blocks have an
+// RRule, with Start and End being the first occurrence.
+type blockRow struct {
+    ID int64
+    UID string
+    Name string
+    Start time.Time
+    End time.Time
+    Note string
+    Modified time.Time
+    Sequence int
+    RRule string
+    ExDates []time.Time
+}
+
+const blockColumns = "id, COALESCE(uid,''), COALESCE(caldav_name,''), start_date, end_date, COALESCE(note,''), updated_at, sequence, COALESCE(rrule,''), exdates"
+
+func scanBlock(row pgx.Row) (blockRow, error) {
+    var b blockRow
+    err := row.Scan(&b.ID, &b.UID, &b.Name, &b.Start, &b.End, &b.Note, &b.Modified, &b.Sequence, &b.RRule, &b.ExDates)
+    return b, err
+}
+
+func (b blockRow) event() icsEvent {
+    uid, note := b.UID, b.Note
+    if uid == "" { uid = blockUID(b.ID) }
+    if note == "" { note = "Bloqueio" }
+    return icsEvent{ UID: uid, Summary: note, Category: "Block", Status: "CONFIRMED", Start: b.Start, End: b.End, Modified: b.Modified, Sequence: b.Sequence, RRule: b.RRule, ExDates: b.ExDates }
+}
+
+// blockAuditState is how blocks appear in audit entries and webhook payloads.
+func blockAuditState(b blockRow) map[string]any {
+    st := map[string]any{"id": b.ID, "from": b.Start.Format(dateLayout), "to": b.End.Format(dateLayout), "note": b.Note}
+    if b.RRule != "" { st["rrule"], st["exdates"] = b.RRule, formatDates(b.ExDates) }
+    return st
+}
+
+func formatDates(ds []time.Time) []string {
+    out := make([]string, len(ds))
+    for i, d := range ds { out[i] = d.Format(dateLayout) }
+    return out
+}
+
+var errExDatesWithoutRRule = errors.New("exdates_without_rrule")
+
+// parseRecurrence validates an optional RRULE and EXDATE list from a request.
+func parseRecurrence(rule string, exdates []string) (string, []time.Time, error) {
+    rule = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(rule), "RRULE:"))
+    if rule == "" && len(exdates) > 0 { return "", nil, errExDatesWithoutRRule }
+    if rule != "" {
+        if _, err := parseRRule(rule); err != nil { return "", nil, err }
+    }
+    out := []time.Time{}
+    for _, v := range exdates {
+        d, err := parseStayDate(v)
+        if err != nil { return "", nil, err }
+        out = append(out, d)
+    }
+    return rule, out, nil
+}
+
+// recurrenceError is the error code for a parseRecurrence failure.
+func recurrenceError(err error) string {
+    if errors.Is(err, errExDatesWithoutRRule) { return errExDatesWithoutRRule.Error() }
+    return "invalid_rrule"
+}
+
+const maxImportBytes = 2 << 20
+
+type importConflict struct {
+    BookingID string `json:"booking_id"`
+    GuestName string `json:"guest_name"`
+    CheckIn string `json:"check_in"`
+    CheckOut string `json:"check_out"`
+    Status string `json:"status"`
+}
+
+// importRange is one VEVENT of an uploaded file as it would become a block.
+// Past and duplicate ranges are shown but not imported.
+type importRange struct {
+    From string `json:"from"`
+    To string `json:"to"`
+    Note string `json:"note"`
+    UID string `json:"uid"`
+    RRule string `json:"rrule,omitempty"`
+    ExDates []string `json:"exdates,omitempty"`
+    UnsupportedRRule string `json:"unsupported_rrule,omitempty"`
+    Past bool `json:"past"`
+    Duplicate bool `json:"duplicate"`
+    Conflicts []importConflict `json:"conflicts"`
+    BlockID *int64 `json:"block_id,omitempty"`
+    start, end time.Time
+    exdates []time.Time
+}
+
+// readImportFile takes the .ics either as a multipart "file" field or as the
+// raw request body.
+func readImportFile(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+    r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+    if err := r.ParseMultipartForm(maxImportBytes); err == nil {
+        f, _, err := r.FormFile("file")
+        if err != nil { return nil, err }
+        defer f.Close()
+        return io.ReadAll(f)
+    } else if err != http.ErrNotMultipart {
+        return nil, err
+    }
+    return io.ReadAll(r.Body)
+}
+
+// previewImport turns the file's events into ranges, marking the ones that
+// are over, already blocked (or repeated in the file), or overlap site bookings.
+// Recurring events stay one recurring block; they are over once their last
+// occurrence is, and conflict with bookings overlapping any occurrence up to
+// the end of the expansion window. An RRULE that can't be expanded is flagged
+// and its event blocks every night up to that window. Overridden instances (RECURRENCE-ID) are
+// excluded from the rule and, unless cancelled, become one-off blocks.
+func (s *Server) previewImport(ctx context.Context, events []icsEvent) ([]importRange, error) {
+    today := dateIn(time.Now())
+    _, horizon := expansionWindow()
+    type booking struct{ c importConflict; start, end time.Time }
+    var bookings []booking
+    rows, err := s.pool.Query(ctx, "SELECT id::text, guest_name, check_in, check_out, status FROM bookings WHERE status <> 'rejected' ORDER BY check_in")
+    if err != nil { return nil, err }
+    for rows.Next() {
+        var b booking
+        if err := rows.Scan(&b.c.BookingID, &b.c.GuestName, &b.start, &b.end, &b.c.Status); err != nil { rows.Close(); return nil, err }
+        b.c.CheckIn, b.c.CheckOut = b.start.Format(dateLayout), b.end.Format(dateLayout)
+        bookings = append(bookings, b)
+    }
+    rows.Close()
+    if rows.Err() != nil { return nil, rows.Err() }
+    out := []importRange{}
+    seen := map[string]bool{}
+    overrides := map[string][]time.Time{}
+    for _, e := range events {
+        if !e.RecurrenceID.IsZero() { overrides[e.UID] = append(overrides[e.UID], e.RecurrenceID) }
+    }
+    for _, e := range events {
+        if !e.RecurrenceID.IsZero() {
+            if e.Status == "CANCELLED" { continue }
+            e.RRule, e.ExDates = "", nil
+        } else if e.RRule != "" {
+            e.ExDates = append(append([]time.Time{}, e.ExDates...), overrides[e.UID]...)
+        }
+        var unsupported string
+        if e.RRule != "" {
+            if _, err := parseRRule(e.RRule); err != nil {
+                unsupported, e.RRule, e.ExDates = e.RRule, "", nil
+                if horizon.After(e.End) { e.End = horizon }
+            }
+        }
+        ir := importRange{ From: e.Start.Format(dateLayout), To: e.End.Format(dateLayout), Note: e.Summary, UID: e.UID, UnsupportedRRule: unsupported, Past: !e.End.After(today), Conflicts: []importConflict{}, start: e.Start, end: e.End }
+        occs := []icsEvent{e}
+        if e.RRule != "" {
+            ir.RRule, ir.ExDates, ir.exdates = e.RRule, formatDates(e.ExDates), e.ExDates
+            occs = occurrences(e, e.Start, horizon)
+            ir.Past = len(occurrences(e, today, horizon)) == 0
+        }
+        if err := s.pool.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM blocks WHERE start_date=$1 AND end_date=$2 AND COALESCE(rrule,'')=$3)", e.Start, e.End, ir.RRule).Scan(&ir.Duplicate); err != nil { return nil, err }
+        key := ir.From+"|"+ir.To+"|"+ir.RRule
+        if seen[key] { ir.Duplicate = true }
+        seen[key] = true
+        for _, b := range bookings {
+            for _, o := range occs {
+                if b.start.Before(o.End) && b.end.After(o.Start) { ir.Conflicts = append(ir.Conflicts, b.c); break }
+            }
+        }
+        out = append(out, ir)
+    }
+    return out, nil
+}
+
+// handleImportBlocks loads a one-off .ics as manual blocks. Without
+// confirm=true it only returns the preview; with it, the same file is
+// imported, taking each note from SUMMARY and skipping past and duplicate
+// ranges. Conflicts with bookings are reported but don't stop the import.
+func (s *Server) handleImportBlocks(w http.ResponseWriter, r *http.Request) {
+    if !s.requireOwner(w, r) { return }
+    data, err := readImportFile(w, r)
+    if err != nil { jsonResp(w, 400, map[string]string{"error":"invalid_file"}); return }
+    events := parseICS(string(data))
+    if len(events) == 0 { jsonResp(w, 400, map[string]string{"error":"no_events"}); return }
+    ranges, err := s.previewImport(r.Context(), events)
+    if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    if r.URL.Query().Get("confirm") != "true" && r.FormValue("confirm") != "true" {
+        jsonResp(w, 200, map[string]any{"data": ranges, "committed": false})
+        return
+    }
+    tx, err := s.pool.Begin(r.Context())
+    if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    defer tx.Rollback(r.Context())
+    var created []int64
+    for i := range ranges {
+        ir := &ranges[i]
+        if ir.Past || ir.Duplicate { continue }
+        var id int64
+        if err := tx.QueryRow(r.Context(), "INSERT INTO blocks (start_date, end_date, note, rrule, exdates) VALUES ($1,$2,$3,NULLIF($4,''),$5) RETURNING id", ir.start, ir.end, ir.Note, ir.RRule, append([]time.Time{}, ir.exdates...)).Scan(&id); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+        ir.BlockID = &id
+        created = append(created, id)
+    }
+    if err := tx.Commit(r.Context()); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    if len(created) > 0 { s.invalidateAvailability() }
+    for _, ir := range ranges {
+        if ir.BlockID == nil { continue }
+        b := blockRow{ ID: *ir.BlockID, Start: ir.start, End: ir.end, Note: ir.Note, RRule: ir.RRule, ExDates: ir.exdates }
+        s.emitEvent(r.Context(), "block.created", blockAuditState(b))
+        after := blockAuditState(b)
+        after["source"] = "import"
+        s.audit(r, auditEntry{ Action: "block.create", TargetType: "block", TargetID: strconv.FormatInt(b.ID, 10), After: after })
+    }
+    jsonResp(w, 200, map[string]any{"data": ranges, "committed": true, "created": len(created)})
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+    "bytes"
+    "errors"
+    "mime/multipart"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+func TestParseRecurrence(t *testing.T) {
+    tests := []struct {
+        name string
+        rule string
+        exdates []string
+        wantRule string
+        wantExDates int
+        wantErr error // nil with fail set: any error
+        fail bool
+    }{
+        {"none", "", nil, "", 0, nil, false},
+        {"prefix and spaces", " RRULE:FREQ=WEEKLY;BYDAY=SA ", nil, "FREQ=WEEKLY;BYDAY=SA", 0, nil, false},
+        {"with exdates", "FREQ=WEEKLY", []string{"2026-01-10", "2026-01-17T12:00:00-03:00"}, "FREQ=WEEKLY", 2, nil, false},
+        {"exdates without rule", "", []string{"2026-01-10"}, "", 0, errExDatesWithoutRRule, true},
+        {"unsupported rule", "FREQ=HOURLY", nil, "", 0, errUnsupportedRRule, true},
+        {"bad exdate", "FREQ=WEEKLY", []string{"10/01/2026"}, "", 0, nil, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            rule, exdates, err := parseRecurrence(tt.rule, tt.exdates)
+            if tt.fail {
+                if err == nil { t.Fatalf("expected an error") }
+                if tt.wantErr != nil && !errors.Is(err, tt.wantErr) { t.Errorf("err = %v, want %v", err, tt.wantErr) }
+                return
+            }
+            if err != nil { t.Fatal(err) }
+            if rule != tt.wantRule || len(exdates) != tt.wantExDates { t.Errorf("got %q %v, want %q with %d exdates", rule, exdates, tt.wantRule, tt.wantExDates) }
+            if exdates == nil { t.Errorf("exdates must not be nil, the column is NOT NULL") }
+        })
+    }
+    if got := recurrenceError(errExDatesWithoutRRule); got != "exdates_without_rrule" { t.Errorf("recurrenceError = %q", got) }
+    if got := recurrenceError(errUnsupportedRRule); got != "invalid_rrule" { t.Errorf("recurrenceError = %q", got) }
+}
+
+func TestReadImportFile(t *testing.T) {
+    const ics = "BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n"
+    var form bytes.Buffer
+    mw := multipart.NewWriter(&form)
+    fw, _ := mw.CreateFormFile("file", "blocks.ics")
+    fw.Write([]byte(ics))
+    mw.WriteField("confirm", "true")
+    mw.Close()
+    tests := []struct {
+        name string
+        body string
+        contentType string
+        fail bool
+    }{
+        {"raw body", ics, "text/calendar", false},
+        {"multipart", form.String(), mw.FormDataContentType(), false},
+        {"multipart without file", "--x--\r\n", "multipart/form-data; boundary=x", true},
+        {"too large", strings.Repeat("a", maxImportBytes+1), "text/calendar", true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            r := httptest.NewRequest("POST", "/blocks/import", strings.NewReader(tt.body))
+            r.Header.Set("Content-Type", tt.contentType)
+            data, err := readImportFile(httptest.NewRecorder(), r)
+            if tt.fail {
+                if err == nil { t.Fatalf("expected an error") }
+                return
+            }
+            if err != nil { t.Fatal(err) }
+            if string(data) != ics { t.Errorf("data = %q", data) }
+        })
+    }
+}
@@ -0,0 +1,498 @@
+package main
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/xml"
+    "errors"
+    "io"
+    "net/http"
+    "net/url"
+    "path"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+    "github.com/golang-jwt/jwt/v5"
+    "github.com/gorilla/mux"
+    "github.com/jackc/pgx/v5/pgconn"
+)
+
+// The CalDAV server exposes one calendar to owners' calendar apps:
+//
+//   /caldav/            principal and calendar home
+//   /caldav/calendar/   the calendar collection
+//   /caldav/calendar/x  one VEVENT per resource
+//
+// Blocks can be created, edited and deleted; bookings and imported events
+// are listed but read-only. Apps sign in with Basic auth, using the account
+// email and an app password, or an API key as the password.
+
+const (
+    caldavHome = "/caldav/"
+    caldavCalendarPath = "/caldav/calendar/"
+    caldavDAVHeader = "1, 3, calendar-access"
+    caldavAllow = "OPTIONS, GET, HEAD, PUT, DELETE, PROPFIND, REPORT"
+    nsDAV = "DAV:"
+    nsCalDAV = "urn:ietf:params:xml:ns:caldav"
+    nsCalServer = "http://calendarserver.org/ns/"
+)
+
+// caldavAuth authenticates with Basic auth and lets only owners through. API
+// keys need ical:read, plus blocks:write for PUT and DELETE.
+func (s *Server) caldavAuth(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("DAV", caldavDAVHeader)
+        user, pass, ok := r.BasicAuth()
+        var claims jwt.MapClaims
+        err := errInvalidAppPassword
+        if ok && strings.HasPrefix(pass, apiKeyPrefix) {
+            claims, err = s.authenticateAPIKey(r.Context(), pass)
+            if err == nil {
+                scopes := []string{"ical:read"}
+                if r.Method == http.MethodPut || r.Method == http.MethodDelete { scopes = append(scopes, "blocks:write") }
+                granted, _ := claims["scopes"].([]string)
+                if !hasScopes(granted, scopes) { http.Error(w, "insufficient_scope", http.StatusForbidden); return }
+            }
+        } else if ok {
+            claims, err = s.authenticateAppPassword(r.Context(), user, pass)
+        }
+        if err != nil && !errors.Is(err, errInvalidAPIKey) && !errors.Is(err, errInvalidAppPassword) { http.Error(w, err.Error(), 500); return }
+        if err != nil {
+            w.Header().Set("WWW-Authenticate", `Basic realm="Ocean Haven", charset="UTF-8"`)
+            http.Error(w, "unauthorized", http.StatusUnauthorized)
+            return
+        }
+        if isOwner, _ := claims["is_owner"].(bool); !isOwner { http.Error(w, "forbidden", http.StatusForbidden); return }
+        next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), "user", claims)))
+    })
+}
+
+// handleCalDAVWellKnown points clients doing service discovery (RFC 6764) at
+// the principal.
+func handleCalDAVWellKnown(w http.ResponseWriter, r *http.Request) {
+    http.Redirect(w, r, caldavHome, http.StatusMovedPermanently)
+}
+
+var caldavNameRe = regexp.MustCompile(`^[A-Za-z0-9@._-]{1,200}$`)
+
+// caldavName is the resource name for an event UID. UIDs that aren't safe in
+// a path are hashed.
+func caldavName(uid string) string {
+    if caldavNameRe.MatchString(uid) { return uid + ".ics" }
+    sum := sha256.Sum256([]byte(uid))
+    return "uid-" + hex.EncodeToString(sum[:16]) + ".ics"
+}
+
+// caldavName keeps the name a calendar app chose when it created the block.
+func (b blockRow) caldavName() string {
+    if b.Name != "" { return b.Name }
+    return caldavName(b.event().UID)
+}
+
+// caldavETag changes whenever anything written into the resource does.
+func caldavETag(e icsEvent) string {
+    sum := sha256.Sum256([]byte(strings.Join([]string{ e.UID, e.Summary, e.Status, e.Category, e.Start.Format(dateLayout), e.End.Format(dateLayout), strconv.Itoa(e.Sequence), e.Modified.UTC().Format(time.RFC3339Nano), e.RRule, strings.Join(formatDates(e.ExDates), ",") }, "|")))
+    return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+func caldavData(e icsEvent) string {
+    var cal icsWriter
+    cal.begin("CalDAV")
+    cal.event(e)
+    cal.end()
+    return cal.String()
+}
+
+type caldavResource struct {
+    Name string
+    Event icsEvent
+    Block *blockRow // nil for read-only events
+}
+
+func (s *Server) caldavBlocks(ctx context.Context) ([]blockRow, error) {
+    rows, err := s.pool.Query(ctx, "SELECT "+blockColumns+" FROM blocks")
+    if err != nil { return nil, err }
+    defer rows.Close()
+    var out []blockRow
+    for rows.Next() {
+        b, err := scanBlock(rows)
+        if err != nil { return nil, err }
+        out = append(out, b)
+    }
+    return out, rows.Err()
+}
+
+// caldavResources lists the calendar as resources sorted by name. Blocks come
+// first so an imported event reusing a block's UID can't shadow it. Imported
+// events are the stored ones from the last successful sync, so a feed that is
+// failing keeps its events and the CTag doesn't change under clients.
+func (s *Server) caldavResources(ctx context.Context) ([]caldavResource, error) {
+    blocks, err := s.caldavBlocks(ctx)
+    if err != nil { return nil, err }
+    events, err := s.collectCalendarEvents(ctx, 0)
+    if err != nil { return nil, err }
+    var out []caldavResource
+    seen := map[string]bool{}
+    for i := range blocks {
+        b := &blocks[i]
+        out = append(out, caldavResource{ Name: b.caldavName(), Event: b.event(), Block: b })
+        seen[b.event().UID], seen[b.caldavName()] = true, true
+    }
+    for _, e := range events {
+        if e.Category == "Block" || seen[e.UID] || seen[caldavName(e.UID)] { continue }
+        seen[e.UID], seen[caldavName(e.UID)] = true, true
+        out = append(out, caldavResource{ Name: caldavName(e.UID), Event: e })
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+    return out, nil
+}
+
+// caldavCTag summarizes the collection so clients can skip unchanged syncs.
+func caldavCTag(rs []caldavResource) string {
+    h := sha256.New()
+    for _, res := range rs { io.WriteString(h, res.Name+caldavETag(res.Event)+"\n") }
+    return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// davRequest is the part of a PROPFIND or REPORT body the server acts on.
+type davRequest struct {
+    Root string
+    Props []xml.Name
+    AllProp bool
+    Hrefs []string
+    Start time.Time
+    End time.Time
+}
+
+func parseDAVRequest(body io.Reader) (davRequest, error) {
+    var req davRequest
+    dec := xml.NewDecoder(body)
+    var stack []string
+    for {
+        tok, err := dec.Token()
+        if err == io.EOF { break }
+        if err != nil { return req, err }
+        switch t := tok.(type) {
+        case xml.StartElement:
+            if len(stack) == 0 { req.Root = t.Name.Local }
+            if len(stack) == 2 && stack[1] == "prop" { req.Props = append(req.Props, t.Name) }
+            switch t.Name.Local {
+            case "allprop": req.AllProp = true
+            case "href":
+                var h string
+                if err := dec.DecodeElement(&h, &t); err != nil { return req, err }
+                req.Hrefs = append(req.Hrefs, strings.TrimSpace(h))
+                continue
+            case "time-range":
+                for _, a := range t.Attr {
+                    v, err := time.Parse("20060102T150405Z", a.Value)
+                    if err != nil { continue }
+                    if a.Name.Local == "start" { req.Start = v }
+                    if a.Name.Local == "end" { req.End = v }
+                }
+            }
+            stack = append(stack, t.Name.Local)
+        case xml.EndElement:
+            if len(stack) > 0 { stack = stack[:len(stack)-1] }
+        }
+    }
+    if req.Root == "" || (req.Root == "propfind" && len(req.Props) == 0) { req.AllProp = true }
+    return req, nil
+}
+
+// davTag renders a property element, using the multistatus prefixes for
+// known namespaces.
+func davTag(name xml.Name, inner string) string {
+    prefix, decl := "", ""
+    switch name.Space {
+    case nsDAV: prefix = "D:"
+    case nsCalDAV: prefix = "C:"
+    case nsCalServer: prefix = "CS:"
+    default:
+        var b strings.Builder
+        _ = xml.EscapeText(&b, []byte(name.Space))
+        prefix, decl = "X:", ` xmlns:X="`+b.String()+`"`
+    }
+    if inner == "" { return "<" + prefix + name.Local + decl + "/>" }
+    return "<" + prefix + name.Local + decl + ">" + inner + "</" + prefix + name.Local + ">"
+}
+
+func davHref(p string) string { return "<D:href>" + xmlText((&url.URL{Path: p}).EscapedPath()) + "</D:href>" }
+
+func xmlText(s string) string {
+    var b strings.Builder
+    _ = xml.EscapeText(&b, []byte(s))
+    return b.String()
+}
+
+// davTarget is something PROPFIND and REPORT describe: the home, the
+// calendar or one resource.
+type davTarget struct {
+    Href string
+    Props map[xml.Name]string
+    Default []xml.Name // returned for allprop
+}
+
+func (t davTarget) response(req davRequest) string {
+    names := req.Props
+    if req.AllProp { names = t.Default }
+    var found, missing strings.Builder
+    for _, n := range names {
+        if v, ok := t.Props[n]; ok { found.WriteString(davTag(n, v)) } else { missing.WriteString(davTag(n, "")) }
+    }
+    out := "<D:response>" + davHref(t.Href)
+    if found.Len() > 0 { out += "<D:propstat><D:prop>" + found.String() + "</D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat>" }
+    if missing.Len() > 0 { out += "<D:propstat><D:prop>" + missing.String() + "</D:prop><D:status>HTTP/1.1 404 Not Found</D:status></D:propstat>" }
+    return out + "</D:response>"
+}
+
+func writeMultistatus(w http.ResponseWriter, responses []string) {
+    w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+    w.WriteHeader(207)
+    _, _ = io.WriteString(w, xml.Header+`<D:multistatus xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav" xmlns:CS="http://calendarserver.org/ns/">`+strings.Join(responses, "")+"</D:multistatus>")
+}
+
+var (
+    davResourceType = xml.Name{Space: nsDAV, Local: "resourcetype"}
+    davDisplayName = xml.Name{Space: nsDAV, Local: "displayname"}
+    davETag = xml.Name{Space: nsDAV, Local: "getetag"}
+    davContentType = xml.Name{Space: nsDAV, Local: "getcontenttype"}
+    davPrincipal = xml.Name{Space: nsDAV, Local: "current-user-principal"}
+    davPrincipalURL = xml.Name{Space: nsDAV, Local: "principal-URL"}
+    davPrivileges = xml.Name{Space: nsDAV, Local: "current-user-privilege-set"}
+    davReports = xml.Name{Space: nsDAV, Local: "supported-report-set"}
+    calHomeSet = xml.Name{Space: nsCalDAV, Local: "calendar-home-set"}
+    calComponents = xml.Name{Space: nsCalDAV, Local: "supported-calendar-component-set"}
+    calData = xml.Name{Space: nsCalDAV, Local: "calendar-data"}
+    csCTag = xml.Name{Space: nsCalServer, Local: "getctag"}
+)
+
+const (
+    davReadPrivileges = "<D:privilege><D:read/></D:privilege><D:privilege><D:read-current-user-privilege-set/></D:privilege>"
+    davWritePrivileges = davReadPrivileges + "<D:privilege><D:write/></D:privilege><D:privilege><D:write-content/></D:privilege><D:privilege><D:bind/></D:privilege><D:privilege><D:unbind/></D:privilege>"
+)
+
+func homeTarget() davTarget {
+    return davTarget{
+        Href: caldavHome,
+        Props: map[xml.Name]string{
+            davResourceType: "<D:collection/><D:principal/>",
+            davDisplayName: "Ocean Haven",
+            davPrincipal: davHref(caldavHome),
+            davPrincipalURL: davHref(caldavHome),
+            calHomeSet: davHref(caldavHome),
+            davPrivileges: davReadPrivileges,
+        },
+        Default: []xml.Name{davResourceType, davDisplayName, davPrincipal, calHomeSet},
+    }
+}
+
+func calendarTarget(rs []caldavResource) davTarget {
+    return davTarget{
+        Href: caldavCalendarPath,
+        Props: map[xml.Name]string{
+            davResourceType: "<D:collection/><C:calendar/>",
+            davDisplayName: "Ocean Haven",
+            davPrincipal: davHref(caldavHome),
+            davPrivileges: davWritePrivileges,
+            davReports: "<D:supported-report><D:report><C:calendar-query/></D:report></D:supported-report><D:supported-report><D:report><C:calendar-multiget/></D:report></D:supported-report>",
+            calComponents: `<C:comp name="VEVENT"/>`,
+            csCTag: xmlText(caldavCTag(rs)),
+        },
+        Default: []xml.Name{davResourceType, davDisplayName, calComponents, csCTag},
+    }
+}
+
+func resourceTarget(res caldavResource) davTarget {
+    privileges := davReadPrivileges
+    if res.Block != nil { privileges = davWritePrivileges }
+    return davTarget{
+        Href: caldavCalendarPath + res.Name,
+        Props: map[xml.Name]string{
+            davResourceType: "",
+            davETag: xmlText(caldavETag(res.Event)),
+            davContentType: "text/calendar; charset=utf-8; component=vevent",
+            davPrivileges: privileges,
+            calData: xmlText(caldavData(res.Event)),
+        },
+        Default: []xml.Name{davResourceType, davETag, davContentType},
+    }
+}
+
+// resourceName takes the last path segment of an href or request path.
+func resourceName(p string) string {
+    if u, err := url.Parse(p); err == nil { p = u.Path }
+    return path.Base(p)
+}
+
+func findResource(rs []caldavResource, name string) *caldavResource {
+    for i := range rs { if rs[i].Name == name { return &rs[i] } }
+    return nil
+}
+
+func (s *Server) handleCalDAVPropfind(w http.ResponseWriter, r *http.Request) {
+    req, err := parseDAVRequest(r.Body)
+    if err != nil { http.Error(w, "invalid_xml", 400); return }
+    depth1 := r.Header.Get("Depth") != "0"
+    name, isResource := mux.Vars(r)["name"]
+    if !isResource && !strings.HasPrefix(r.URL.Path, strings.TrimSuffix(caldavCalendarPath, "/")) {
+        out := []string{homeTarget().response(req)}
+        if depth1 {
+            rs, err := s.caldavResources(r.Context())
+            if err != nil { http.Error(w, err.Error(), 500); return }
+            out = append(out, calendarTarget(rs).response(req))
+        }
+        writeMultistatus(w, out)
+        return
+    }
+    rs, err := s.caldavResources(r.Context())
+    if err != nil { http.Error(w, err.Error(), 500); return }
+    if isResource {
+        res := findResource(rs, name)
+        if res == nil { http.Error(w, "not_found", 404); return }
+        writeMultistatus(w, []string{resourceTarget(*res).response(req)})
+        return
+    }
+    out := []string{calendarTarget(rs).response(req)}
+    if depth1 {
+        for _, res := range rs { out = append(out, resourceTarget(res).response(req)) }
+    }
+    writeMultistatus(w, out)
+}
+
+// handleCalDAVReport answers calendar-query (optionally limited by a
+// time-range) and calendar-multiget on the calendar collection.
+func (s *Server) handleCalDAVReport(w http.ResponseWriter, r *http.Request) {
+    req, err := parseDAVRequest(r.Body)
+    if err != nil { http.Error(w, "invalid_xml", 400); return }
+    if req.Root != "calendar-query" && req.Root != "calendar-multiget" {
+        writeDAVError(w, http.StatusForbidden, "D:supported-report")
+        return
+    }
+    if req.AllProp { req.AllProp, req.Props = false, []xml.Name{davETag, calData} }
+    rs, err := s.caldavResources(r.Context())
+    if err != nil { http.Error(w, err.Error(), 500); return }
+    var out []string
+    if req.Root == "calendar-multiget" {
+        for _, h := range req.Hrefs {
+            res := findResource(rs, resourceName(h))
+            if res == nil { out = append(out, "<D:response>"+davHref(caldavCalendarPath+resourceName(h))+"<D:status>HTTP/1.1 404 Not Found</D:status></D:response>"); continue }
+            out = append(out, resourceTarget(*res).response(req))
+        }
+        writeMultistatus(w, out)
+        return
+    }
+    from, to := dateOnly(1, 1, 1), dateOnly(9999, 1, 1)
+    if !req.Start.IsZero() { from = dateIn(req.Start) }
+    if !req.End.IsZero() { to = dateIn(req.End.Add(-time.Second)).AddDate(0, 0, 1) }
+    for _, res := range rs {
+        if len(occurrences(res.Event, from, to)) == 0 { continue }
+        out = append(out, resourceTarget(res).response(req))
+    }
+    writeMultistatus(w, out)
+}
+
+func (s *Server) handleCalDAVGet(w http.ResponseWriter, r *http.Request) {
+    rs, err := s.caldavResources(r.Context())
+    if err != nil { http.Error(w, err.Error(), 500); return }
+    res := findResource(rs, mux.Vars(r)["name"])
+    if res == nil { http.Error(w, "not_found", 404); return }
+    w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+    w.Header().Set("ETag", caldavETag(res.Event))
+    if r.Method == http.MethodHead { return }
+    _, _ = io.WriteString(w, caldavData(res.Event))
+}
+
+// caldavPrecondition applies If-Match / If-None-Match to the current
+// resource (nil when it doesn't exist) and reports whether to go on.
+func caldavPrecondition(w http.ResponseWriter, r *http.Request, current *icsEvent) bool {
+    if inm := r.Header.Get("If-None-Match"); inm == "*" && current != nil { http.Error(w, "precondition_failed", http.StatusPreconditionFailed); return false }
+    if im := r.Header.Get("If-Match"); im != "" && (current == nil || (im != "*" && im != caldavETag(*current))) { http.Error(w, "precondition_failed", http.StatusPreconditionFailed); return false }
+    return true
+}
+
+// writeDAVError answers with a DAV:error body naming the failed
+// precondition, such as "C:valid-calendar-object-resource".
+func writeDAVError(w http.ResponseWriter, code int, condition string) {
+    w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+    w.WriteHeader(code)
+    _, _ = io.WriteString(w, xml.Header+`<D:error xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav"><`+condition+`/></D:error>`)
+}
+
+// caldavObject picks the block a PUT body describes, or returns the CalDAV
+// precondition it fails.
+func caldavObject(events []icsEvent) (icsEvent, string) {
+    if len(events) == 0 || events[0].UID == "" { return icsEvent{}, "C:valid-calendar-object-resource" }
+    e := events[0]
+    for _, o := range events[1:] { if o.UID != e.UID { return icsEvent{}, "C:valid-calendar-object-resource" } }
+    // Blocks have no per-instance edits. Accepting a RECURRENCE-ID override
+    // and dropping it would report success for a change that never happened.
+    for _, o := range events { if !o.RecurrenceID.IsZero() { return icsEvent{}, "C:supported-calendar-data" } }
+    // A block needs an explicit end after its start; guessing one would
+    // close nights the client never asked for.
+    if e.EndImplied || !e.End.After(e.Start) { return icsEvent{}, "C:valid-calendar-object-resource" }
+    if e.RRule != "" {
+        if _, err := parseRRule(e.RRule); err != nil { return icsEvent{}, "C:supported-calendar-data" }
+    }
+    if e.ExDates == nil { e.ExDates = []time.Time{} }
+    return e, ""
+}
+
+// handleCalDAVPut creates or edits a block. Only the dates, SUMMARY, RRULE
+// and EXDATE are kept; events on bookings and imported feeds can't be
+// changed from here.
+func (s *Server) handleCalDAVPut(w http.ResponseWriter, r *http.Request) {
+    name := mux.Vars(r)["name"]
+    body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+    if err != nil { http.Error(w, err.Error(), 400); return }
+    e, condition := caldavObject(parseICS(string(body)))
+    if condition != "" { writeDAVError(w, http.StatusForbidden, condition); return }
+    rs, err := s.caldavResources(r.Context())
+    if err != nil { http.Error(w, err.Error(), 500); return }
+    existing := findResource(rs, name)
+    if existing != nil && existing.Block == nil { http.Error(w, "read_only", http.StatusForbidden); return }
+    var current *icsEvent
+    if existing != nil { current = &existing.Event }
+    if !caldavPrecondition(w, r, current) { return }
+    for _, res := range rs {
+        if res.Event.UID == e.UID && res.Name != name { http.Error(w, "no-uid-conflict", http.StatusConflict); return }
+    }
+    if existing == nil {
+        b, err := scanBlock(s.pool.QueryRow(r.Context(), "INSERT INTO blocks (start_date, end_date, note, uid, caldav_name, rrule, exdates) VALUES ($1,$2,$3,$4,$5,NULLIF($6,''),$7) RETURNING "+blockColumns, e.Start, e.End, e.Summary, e.UID, name, e.RRule, e.ExDates))
+        var pgErr *pgconn.PgError
+        if errors.As(err, &pgErr) && pgErr.Code == "23505" { http.Error(w, "no-uid-conflict", http.StatusConflict); return }
+        if err != nil { http.Error(w, err.Error(), 500); return }
+        s.invalidateAvailability()
+        s.emitEvent(r.Context(), "block.created", blockAuditState(b))
+        s.audit(r, auditEntry{ Action: "block.create", TargetType: "block", TargetID: strconv.FormatInt(b.ID, 10), After: blockAuditState(b) })
+        w.Header().Set("ETag", caldavETag(b.event()))
+        w.WriteHeader(http.StatusCreated)
+        return
+    }
+    before := *existing.Block
+    b, err := scanBlock(s.pool.QueryRow(r.Context(), "UPDATE blocks SET start_date=$2, end_date=$3, note=$4, rrule=NULLIF($5,''), exdates=$6 WHERE id=$1 RETURNING "+blockColumns, before.ID, e.Start, e.End, e.Summary, e.RRule, e.ExDates))
+    if err != nil { http.Error(w, err.Error(), 500); return }
+    s.invalidateAvailability()
+    s.emitEvent(r.Context(), "block.updated", blockAuditState(b))
+    s.audit(r, auditEntry{ Action: "block.update", TargetType: "block", TargetID: strconv.FormatInt(b.ID, 10), Before: blockAuditState(before), After: blockAuditState(b) })
+    w.Header().Set("ETag", caldavETag(b.event()))
+    w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleCalDAVDelete(w http.ResponseWriter, r *http.Request) {
+    rs, err := s.caldavResources(r.Context())
+    if err != nil { http.Error(w, err.Error(), 500); return }
+    res := findResource(rs, mux.Vars(r)["name"])
+    if res == nil { http.Error(w, "not_found", 404); return }
+    if res.Block == nil { http.Error(w, "read_only", http.StatusForbidden); return }
+    if !caldavPrecondition(w, r, &res.Event) { return }
+    if _, err := s.pool.Exec(r.Context(), "DELETE FROM blocks WHERE id=$1", res.Block.ID); err != nil { http.Error(w, err.Error(), 500); return }
+    s.invalidateAvailability()
+    before := blockAuditState(*res.Block)
+    s.emitEvent(r.Context(), "block.deleted", before)
+    s.audit(r, auditEntry{ Action: "block.delete", TargetType: "block", TargetID: strconv.FormatInt(res.Block.ID, 10), Before: before })
+    w.WriteHeader(http.StatusNoContent)
+}
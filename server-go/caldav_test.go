@@ -0,0 +1,35 @@
+package main
+
+import (
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+func TestCalDAVObject(t *testing.T) {
+    cal := func(events ...string) []icsEvent {
+        return parseICS("BEGIN:VCALENDAR\r\nVERSION:2.0\r\n" + strings.Join(events, "\r\n") + "\r\nEND:VCALENDAR\r\n")
+    }
+    block := "BEGIN:VEVENT\r\nUID:b1\r\nSUMMARY:Pintura\r\nDTSTART;VALUE=DATE:20260110\r\nDTEND;VALUE=DATE:20260113\r\nEND:VEVENT"
+    series := "BEGIN:VEVENT\r\nUID:b1\r\nRRULE:FREQ=WEEKLY\r\nDTSTART;VALUE=DATE:20260110\r\nDTEND;VALUE=DATE:20260111\r\nEND:VEVENT"
+    tests := []struct{ name string; events []icsEvent; want string }{
+        {"single block", cal(block), ""},
+        {"weekly series", cal(series), ""},
+        {"empty body", nil, "C:valid-calendar-object-resource"},
+        {"two UIDs", cal(block, strings.Replace(block, "UID:b1", "UID:b2", 1)), "C:valid-calendar-object-resource"},
+        {"override", cal(series, "BEGIN:VEVENT\r\nUID:b1\r\nRECURRENCE-ID;VALUE=DATE:20260117\r\nDTSTART;VALUE=DATE:20260118\r\nDTEND;VALUE=DATE:20260119\r\nEND:VEVENT"), "C:supported-calendar-data"},
+        {"no end", cal("BEGIN:VEVENT\r\nUID:b1\r\nDTSTART;VALUE=DATE:20260110\r\nEND:VEVENT"), "C:valid-calendar-object-resource"},
+        {"unsupported rule", cal(strings.Replace(series, "FREQ=WEEKLY", "FREQ=HOURLY", 1)), "C:supported-calendar-data"},
+    }
+    for _, tt := range tests {
+        e, got := caldavObject(tt.events)
+        if got != tt.want { t.Errorf("%s: condition = %q, want %q", tt.name, got, tt.want); continue }
+        if got == "" && (e.UID != "b1" || e.ExDates == nil) { t.Errorf("%s: event = %+v", tt.name, e) }
+    }
+}
+
+func TestWriteDAVError(t *testing.T) {
+    rec := httptest.NewRecorder()
+    writeDAVError(rec, 403, "C:supported-calendar-data")
+    if rec.Code != 403 || !strings.Contains(rec.Body.String(), `<D:error xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav"><C:supported-calendar-data/></D:error>`) { t.Errorf("got %d %s", rec.Code, rec.Body) }
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "log"
+    "os"
+    "regexp"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+    _ "time/tzdata"
+)
+
+// Stays and blocks are ranges of calendar dates in the property's time zone:
+// start is the first night, end is the checkout day (exclusive). Dates are
+// carried as time.Time at UTC midnight so they compare and add cleanly.
+
+const dateLayout = "2006-01-02"
+
+var (
+    propertyLocOnce sync.Once
+    propertyLoc *time.Location
+)
+
+// propertyLocation is PROPERTY_TZ, defaulting to America/Sao_Paulo.
+func propertyLocation() *time.Location {
+    propertyLocOnce.Do(func() {
+        name := os.Getenv("PROPERTY_TZ")
+        if name == "" { name = "America/Sao_Paulo" }
+        loc, err := time.LoadLocation(name)
+        if err != nil { log.Printf("PROPERTY_TZ %q inválido, usando UTC: %v", name, err); loc = time.UTC }
+        propertyLoc = loc
+    })
+    return propertyLoc
+}
+
+// propertyID (PROPERTY_ID, default ocean-haven) names the property in UIDs.
+func propertyID() string {
+    if id := os.Getenv("PROPERTY_ID"); id != "" { return id }
+    return "ocean-haven"
+}
+
+// blockUID is stable for the life of the block, so platforms update the
+// event in place instead of seeing it vanish and reappear.
+func blockUID(id int64) string { return "block-" + strconv.FormatInt(id, 10) + "@" + propertyID() }
+
+// importedUID stands in for a missing UID on an imported event, derived from
+// what identifies the event in its feed.
+func importedUID(source string, e icsEvent) string {
+    sum := sha256.Sum256([]byte(source + "|" + e.Start.Format(dateLayout) + "|" + e.End.Format(dateLayout) + "|" + e.Summary))
+    return "import-" + hex.EncodeToString(sum[:12]) + "@" + propertyID()
+}
+
+func dateOnly(y int, m time.Month, d int) time.Time { return time.Date(y, m, d, 0, 0, 0, 0, time.UTC) }
+
+// dateIn returns the calendar date of instant t as seen at the property.
+func dateIn(t time.Time) time.Time {
+    y, m, d := t.In(propertyLocation()).Date()
+    return dateOnly(y, m, d)
+}
+
+// propertyMidnight turns a date back into local midnight at the property.
+// JSON responses use it so clients that do new Date(...) land on the right day.
+func propertyMidnight(d time.Time) time.Time {
+    y, m, dd := d.Date()
+    return time.Date(y, m, dd, 0, 0, 0, 0, propertyLocation())
+}
+
+// parseStayDate accepts a plain date (2026-01-10) or an RFC3339 instant such
+// as the frontend's toISOString(), which is read in the property time zone.
+func parseStayDate(s string) (time.Time, error) {
+    if d, err := time.Parse(dateLayout, s); err == nil { return d, nil }
+    t, err := time.Parse(time.RFC3339, s)
+    if err != nil { return time.Time{}, err }
+    return dateIn(t), nil
+}
+
+var errInvalidRange = errors.New("invalid_range")
+
+// parseDateRange parses a [from, to) pair and requires to > from.
+func parseDateRange(from, to string) (time.Time, time.Time, error) {
+    f, err := parseStayDate(from)
+    if err != nil { return f, f, err }
+    t, err := parseStayDate(to)
+    if err != nil { return f, t, err }
+    if !t.After(f) { return f, t, errInvalidRange }
+    return f, t, nil
+}
+
+// icsEvent is a VEVENT reduced to what the calendars need.
+type icsEvent struct {
+    UID string
+    Summary string
+    Status string
+    Category string
+    Start time.Time
+    End time.Time
+    Stamp time.Time
+    Modified time.Time
+    Sequence int
+    RRule string
+    ExDates []time.Time
+    RecurrenceID time.Time // set on an instance of a recurring event
+    EndImplied bool // no DTEND or DURATION, or one not after DTSTART; End was made Start+1
+}
+
+type icsProp struct {
+    Name string
+    Params map[string]string
+    Value string
+}
+
+// unfoldICS joins folded content lines (RFC 5545 §3.1).
+func unfoldICS(data string) []string {
+    data = strings.ReplaceAll(data, "\r\n", "\n")
+    data = strings.ReplaceAll(data, "\n ", "")
+    data = strings.ReplaceAll(data, "\n\t", "")
+    return strings.Split(data, "\n")
+}
+
+func parseICSLine(line string) (icsProp, bool) {
+    inQuote, colon := false, -1
+    for i, c := range line {
+        if c == '"' { inQuote = !inQuote }
+        if c == ':' && !inQuote { colon = i; break }
+    }
+    if colon < 0 { return icsProp{}, false }
+    parts := strings.Split(line[:colon], ";")
+    p := icsProp{ Name: strings.ToUpper(parts[0]), Params: map[string]string{}, Value: line[colon+1:] }
+    for _, kv := range parts[1:] {
+        k, v, _ := strings.Cut(kv, "=")
+        p.Params[strings.ToUpper(k)] = strings.Trim(v, `"`)
+    }
+    return p, true
+}
+
+func unescapeICS(s string) string {
+    r := strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`)
+    return r.Replace(s)
+}
+
+// parseICSTime reads a DATE or DATE-TIME value. Floating times and unknown
+// TZIDs are taken as property-local.
+func parseICSTime(p icsProp) (t time.Time, allDay bool, err error) {
+    v := strings.TrimSpace(p.Value)
+    if p.Params["VALUE"] == "DATE" || len(v) == 8 {
+        t, err = time.Parse("20060102", v)
+        return t, true, err
+    }
+    if strings.HasSuffix(v, "Z") {
+        t, err = time.Parse("20060102T150405Z", v)
+        return t, false, err
+    }
+    loc := propertyLocation()
+    if tz := p.Params["TZID"]; tz != "" {
+        if l, e := time.LoadLocation(tz); e == nil { loc = l }
+    }
+    t, err = time.ParseInLocation("20060102T150405", v, loc)
+    return t, false, err
+}
+
+var icsDurationRe = regexp.MustCompile(`^([+-])?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+func parseICSDuration(s string) (time.Duration, bool) {
+    m := icsDurationRe.FindStringSubmatch(strings.TrimSpace(s))
+    if m == nil { return 0, false }
+    n := func(i int) time.Duration { v, _ := strconv.Atoi(m[i]); return time.Duration(v) }
+    d := n(2)*7*24*time.Hour + n(3)*24*time.Hour + n(4)*time.Hour + n(5)*time.Minute + n(6)*time.Second
+    if m[1] == "-" { d = -d }
+    return d, true
+}
+
+// parseICS extracts VEVENTs and normalizes them to property-local date
+// ranges: all-day events keep their dates, timed events cover every day they
+// touch up to the checkout day. Events without a usable DTSTART are skipped.
+func parseICS(data string) []icsEvent {
+    var out []icsEvent
+    var props []icsProp
+    depth, inEvent := 0, false
+    for _, line := range unfoldICS(data) {
+        line = strings.TrimRight(line, "\r")
+        p, ok := parseICSLine(line)
+        if !ok { continue }
+        switch {
+        case p.Name == "BEGIN" && strings.EqualFold(p.Value, "VEVENT"):
+            inEvent, depth, props = true, 0, nil
+            continue
+        case !inEvent:
+            continue
+        case p.Name == "BEGIN":
+            depth++
+            continue
+        case p.Name == "END" && depth > 0:
+            depth--
+            continue
+        case p.Name == "END" && strings.EqualFold(p.Value, "VEVENT"):
+            inEvent = false
+            if e, ok := icsEventFromProps(props); ok { out = append(out, e) }
+            continue
+        }
+        if depth == 0 { props = append(props, p) }
+    }
+    return out
+}
+
+// withoutCancelled drops events a feed marks CANCELLED, which some channels
+// keep publishing after a stay is called off. Cancelled RECURRENCE-ID
+// overrides stay, since they are what removes an instance from its series.
+func withoutCancelled(events []icsEvent) []icsEvent {
+    var out []icsEvent
+    for _, e := range events {
+        if e.Status == "CANCELLED" && e.RecurrenceID.IsZero() { continue }
+        out = append(out, e)
+    }
+    return out
+}
+
+// icsDate reads a DATE or DATE-TIME value as a property-local date.
+func icsDate(p icsProp) (time.Time, error) {
+    t, allDay, err := parseICSTime(p)
+    if err != nil || allDay { return t, err }
+    return dateIn(t), nil
+}
+
+func icsEventFromProps(props []icsProp) (icsEvent, bool) {
+    var e icsEvent
+    var start, end time.Time
+    var startAllDay, endAllDay, hasStart, hasEnd bool
+    var duration time.Duration
+    for _, p := range props {
+        switch p.Name {
+        case "UID": e.UID = strings.TrimSpace(p.Value)
+        case "SUMMARY": e.Summary = unescapeICS(p.Value)
+        case "STATUS": e.Status = strings.ToUpper(strings.TrimSpace(p.Value))
+        case "DTSTART":
+            t, allDay, err := parseICSTime(p)
+            if err == nil { start, startAllDay, hasStart = t, allDay, true }
+        case "DTEND":
+            t, allDay, err := parseICSTime(p)
+            if err == nil { end, endAllDay, hasEnd = t, allDay, true }
+        case "DTSTAMP":
+            if t, _, err := parseICSTime(p); err == nil { e.Stamp = t }
+        case "LAST-MODIFIED":
+            if t, _, err := parseICSTime(p); err == nil { e.Modified = t }
+        case "SEQUENCE":
+            e.Sequence, _ = strconv.Atoi(strings.TrimSpace(p.Value))
+        case "DURATION":
+            if d, ok := parseICSDuration(p.Value); ok { duration = d }
+        case "RRULE": e.RRule = strings.TrimSpace(p.Value)
+        case "EXDATE":
+            for _, v := range strings.Split(p.Value, ",") {
+                if d, err := icsDate(icsProp{ Name: p.Name, Params: p.Params, Value: v }); err == nil { e.ExDates = append(e.ExDates, d) }
+            }
+        case "RECURRENCE-ID":
+            if d, err := icsDate(p); err == nil { e.RecurrenceID = d }
+        }
+    }
+    if !hasStart { return e, false }
+    if !hasEnd && duration > 0 { end, endAllDay, hasEnd = start.Add(duration), startAllDay, true }
+    e.EndImplied = !hasEnd || !end.After(start)
+    if startAllDay { e.Start = start } else { e.Start = dateIn(start) }
+    if hasEnd {
+        if endAllDay { e.End = end } else { e.End = dateIn(end) }
+    }
+    if !e.End.After(e.Start) { e.End = e.Start.AddDate(0, 0, 1) }
+    return e, true
+}
+
+// icsWriter emits CRLF-terminated content lines folded at 75 octets.
+type icsWriter struct{ b strings.Builder }
+
+func (w *icsWriter) line(name, value string) {
+    l := name + ":" + value
+    for len(l) > 75 {
+        cut := 75
+        for cut > 0 && (l[cut]&0xC0) == 0x80 { cut-- }
+        w.b.WriteString(l[:cut] + "\r\n")
+        l = " " + l[cut:]
+    }
+    w.b.WriteString(l + "\r\n")
+}
+
+func (w *icsWriter) String() string { return w.b.String() }
+
+func escapeICS(s string) string {
+    r := strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+    return r.Replace(s)
+}
+
+func (w *icsWriter) begin(name string) {
+    w.line("BEGIN", "VCALENDAR")
+    w.line("VERSION", "2.0")
+    w.line("PRODID", "-//ocean-haven//"+name+"//EN")
+    w.line("CALSCALE", "GREGORIAN")
+}
+
+func (w *icsWriter) end() { w.line("END", "VCALENDAR") }
+
+// event writes e as an all-day event. DTSTAMP is the last revision time,
+// falling back to now for sources that don't provide one.
+func (w *icsWriter) event(e icsEvent) {
+    const stampLayout = "20060102T150405Z"
+    stamp := e.Stamp
+    if stamp.IsZero() { stamp = e.Modified }
+    if stamp.IsZero() { stamp = time.Now() }
+    w.line("BEGIN", "VEVENT")
+    w.line("UID", e.UID)
+    w.line("DTSTAMP", stamp.UTC().Format(stampLayout))
+    if !e.Modified.IsZero() { w.line("LAST-MODIFIED", e.Modified.UTC().Format(stampLayout)) }
+    w.line("SEQUENCE", strconv.Itoa(e.Sequence))
+    w.line("SUMMARY", escapeICS(e.Summary))
+    if e.Category != "" { w.line("CATEGORIES", escapeICS(e.Category)) }
+    w.line("DTSTART;VALUE=DATE", e.Start.Format("20060102"))
+    w.line("DTEND;VALUE=DATE", e.End.Format("20060102"))
+    if e.RRule != "" { w.line("RRULE", e.RRule) }
+    if len(e.ExDates) > 0 {
+        dates := make([]string, len(e.ExDates))
+        for i, d := range e.ExDates { dates[i] = d.Format("20060102") }
+        w.line("EXDATE;VALUE=DATE", strings.Join(dates, ","))
+    }
+    if e.Status != "" { w.line("STATUS", e.Status) }
+    w.line("END", "VEVENT")
+}
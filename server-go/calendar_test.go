@@ -0,0 +1,117 @@
+package main
+
+import (
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestParseICS(t *testing.T) {
+    d := func(s string) time.Time { t, _ := time.Parse(dateLayout, s); return t }
+    cal := func(lines ...string) string {
+        return "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n" + strings.Join(lines, "\r\n") + "\r\nEND:VCALENDAR\r\n"
+    }
+    tests := []struct {
+        name string
+        data string
+        want []icsEvent
+    }{
+        {"all-day", cal("BEGIN:VEVENT", "UID:a", "SUMMARY:Reserved", "DTSTART;VALUE=DATE:20260110", "DTEND;VALUE=DATE:20260113", "END:VEVENT"),
+            []icsEvent{{ UID: "a", Summary: "Reserved", Start: d("2026-01-10"), End: d("2026-01-13") }}},
+        {"timed in UTC read at the property", cal("BEGIN:VEVENT", "UID:b", "DTSTART:20260111T020000Z", "DTEND:20260112T110000Z", "END:VEVENT"),
+            []icsEvent{{ UID: "b", Start: d("2026-01-10"), End: d("2026-01-12") }}},
+        {"TZID", cal("BEGIN:VEVENT", "UID:c", "DTSTART;TZID=Europe/Lisbon:20260110T010000", "DTEND;TZID=Europe/Lisbon:20260111T120000", "END:VEVENT"),
+            []icsEvent{{ UID: "c", Start: d("2026-01-09"), End: d("2026-01-11") }}},
+        {"duration", cal("BEGIN:VEVENT", "UID:d", "DTSTART;VALUE=DATE:20260110", "DURATION:P2D", "END:VEVENT"),
+            []icsEvent{{ UID: "d", Start: d("2026-01-10"), End: d("2026-01-12") }}},
+        {"no end is one night", cal("BEGIN:VEVENT", "UID:e", "DTSTART;VALUE=DATE:20260110", "END:VEVENT"),
+            []icsEvent{{ UID: "e", Start: d("2026-01-10"), End: d("2026-01-11") }}},
+        {"no start is skipped", cal("BEGIN:VEVENT", "UID:f", "DTEND;VALUE=DATE:20260110", "END:VEVENT"), nil},
+        {"folded and escaped summary", cal("BEGIN:VEVENT", "UID:g", "SUMMARY:Airbnb\\, Jo", " ão\\nline", "DTSTART;VALUE=DATE:20260110", "DTEND;VALUE=DATE:20260111", "END:VEVENT"),
+            []icsEvent{{ UID: "g", Summary: "Airbnb, João\nline", Start: d("2026-01-10"), End: d("2026-01-11") }}},
+        {"alarm properties ignored", cal("BEGIN:VEVENT", "UID:h", "DTSTART;VALUE=DATE:20260110", "DTEND;VALUE=DATE:20260111", "BEGIN:VALARM", "UID:alarm", "DTSTART;VALUE=DATE:20250101", "END:VALARM", "END:VEVENT"),
+            []icsEvent{{ UID: "h", Start: d("2026-01-10"), End: d("2026-01-11") }}},
+        {"status, rrule and exdates", cal("BEGIN:VEVENT", "UID:i", "STATUS:cancelled", "RRULE:FREQ=WEEKLY", "EXDATE;VALUE=DATE:20260117,20260124", "DTSTART;VALUE=DATE:20260110", "DTEND;VALUE=DATE:20260111", "END:VEVENT"),
+            []icsEvent{{ UID: "i", Status: "CANCELLED", RRule: "FREQ=WEEKLY", ExDates: []time.Time{d("2026-01-17"), d("2026-01-24")}, Start: d("2026-01-10"), End: d("2026-01-11") }}},
+        {"recurrence override", cal("BEGIN:VEVENT", "UID:j", "RECURRENCE-ID;VALUE=DATE:20260117", "SEQUENCE:2", "DTSTART;VALUE=DATE:20260118", "DTEND;VALUE=DATE:20260119", "END:VEVENT"),
+            []icsEvent{{ UID: "j", Sequence: 2, RecurrenceID: d("2026-01-17"), Start: d("2026-01-18"), End: d("2026-01-19") }}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got := parseICS(tt.data)
+            if len(got) != len(tt.want) { t.Fatalf("got %d events %+v, want %d", len(got), got, len(tt.want)) }
+            for i, w := range tt.want {
+                g := got[i]
+                if g.UID != w.UID || g.Summary != w.Summary || g.Status != w.Status || g.RRule != w.RRule || g.Sequence != w.Sequence || !g.Start.Equal(w.Start) || !g.End.Equal(w.End) || !g.RecurrenceID.Equal(w.RecurrenceID) || len(g.ExDates) != len(w.ExDates) {
+                    t.Errorf("event %d = %+v, want %+v", i, g, w)
+                    continue
+                }
+                for j := range w.ExDates { if !g.ExDates[j].Equal(w.ExDates[j]) { t.Errorf("exdate %d = %v, want %v", j, g.ExDates[j], w.ExDates[j]) } }
+            }
+        })
+    }
+}
+
+func TestWithoutCancelled(t *testing.T) {
+    d := func(s string) time.Time { t, _ := time.Parse(dateLayout, s); return t }
+    events := []icsEvent{
+        { UID: "kept", Status: "CONFIRMED" },
+        { UID: "cancelled", Status: "CANCELLED" },
+        { UID: "series", Status: "CANCELLED", RRule: "FREQ=WEEKLY" },
+        { UID: "override", Status: "CANCELLED", RecurrenceID: d("2026-01-17") },
+        { UID: "no-status" },
+    }
+    var uids []string
+    for _, e := range withoutCancelled(events) { uids = append(uids, e.UID) }
+    if got := strings.Join(uids, ","); got != "kept,override,no-status" { t.Errorf("kept %s", got) }
+}
+
+func TestParseICSEndImplied(t *testing.T) {
+    tests := []struct {
+        name string
+        props []string
+        want bool
+    }{
+        {"all-day with end", []string{"DTSTART;VALUE=DATE:20260110", "DTEND;VALUE=DATE:20260112"}, false},
+        {"timed within one day", []string{"DTSTART:20260110T150000Z", "DTEND:20260110T160000Z"}, false},
+        {"duration", []string{"DTSTART;VALUE=DATE:20260110", "DURATION:P1D"}, false},
+        {"no end", []string{"DTSTART;VALUE=DATE:20260110"}, true},
+        {"end equals start", []string{"DTSTART;VALUE=DATE:20260110", "DTEND;VALUE=DATE:20260110"}, true},
+        {"end before start", []string{"DTSTART;VALUE=DATE:20260110", "DTEND;VALUE=DATE:20260108"}, true},
+    }
+    for _, tt := range tests {
+        evs := parseICS("BEGIN:VEVENT\r\nUID:x\r\n" + strings.Join(tt.props, "\r\n") + "\r\nEND:VEVENT\r\n")
+        if len(evs) != 1 { t.Fatalf("%s: got %d events", tt.name, len(evs)) }
+        if evs[0].EndImplied != tt.want { t.Errorf("%s: EndImplied = %v, want %v", tt.name, evs[0].EndImplied, tt.want) }
+        if !evs[0].End.After(evs[0].Start) { t.Errorf("%s: end %v not after start %v", tt.name, evs[0].End, evs[0].Start) }
+    }
+}
+
+func TestStableUIDs(t *testing.T) {
+    t.Setenv("PROPERTY_ID", "casa-praia")
+    if got := blockUID(42); got != "block-42@casa-praia" { t.Errorf("blockUID(42) = %q", got) }
+    d := func(s string) time.Time { t, _ := time.Parse(dateLayout, s); return t }
+    e := icsEvent{ Summary: "Reserved", Start: d("2026-01-10"), End: d("2026-01-13") }
+    a := importedUID("airbnb:1", e)
+    if a != importedUID("airbnb:1", e) || !strings.HasPrefix(a, "import-") || !strings.HasSuffix(a, "@casa-praia") { t.Errorf("importedUID = %q", a) }
+    if a == importedUID("booking:2", e) { t.Error("same UID for two feeds") }
+    moved := e
+    moved.End = d("2026-01-14")
+    if a == importedUID("airbnb:1", moved) { t.Error("same UID for different dates") }
+}
+
+func TestICSRevisionFields(t *testing.T) {
+    d := func(s string) time.Time { t, _ := time.Parse(dateLayout, s); return t }
+    stamp := time.Date(2026, 1, 2, 10, 30, 0, 0, time.UTC)
+    in := icsEvent{ UID: "block-1@ocean-haven", Summary: "Manutenção; pintura", Start: d("2026-01-10"), End: d("2026-01-12"), Stamp: stamp, Modified: stamp, Sequence: 3 }
+    var w icsWriter
+    w.begin("test")
+    w.event(in)
+    w.end()
+    out := parseICS(w.String())
+    if len(out) != 1 { t.Fatalf("parsed %d events from %q", len(out), w.String()) }
+    got := out[0]
+    if got.UID != in.UID || got.Summary != in.Summary || !got.Start.Equal(in.Start) || !got.End.Equal(in.End) || !got.Stamp.Equal(stamp) || !got.Modified.Equal(stamp) || got.Sequence != 3 {
+        t.Errorf("round trip = %+v, want %+v", got, in)
+    }
+}
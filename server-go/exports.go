@@ -0,0 +1,106 @@
+package main
+
+import (
+    "crypto/subtle"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "strconv"
+    "github.com/gorilla/mux"
+    "github.com/jackc/pgx/v5"
+)
+
+// unavailableSummary is the only text a private export reveals.
+const unavailableSummary = "Unavailable"
+
+// redactEvents strips everything but the dates, so channels learn when the
+// property is taken without guest names, reservation codes or sources.
+// Preparation keeps its summary, which says nothing about the guest.
+func redactEvents(events []icsEvent) []icsEvent {
+    out := make([]icsEvent, len(events))
+    for i, e := range events {
+        if e.Category != "Preparation" { e.Summary = unavailableSummary }
+        e.Category, e.Status = "", "CONFIRMED"
+        out[i] = e
+    }
+    return out
+}
+
+func exportPath(id int64, token string) string {
+    return "/calendar/export/" + strconv.FormatInt(id, 10) + ".ics?token=" + token
+}
+
+// handleChannelExportICS is the feed given to one channel: everything in the
+// merged calendar except events imported from this same feed, so a platform
+// never gets its own reservations back as foreign blocks. Other feeds of the
+// same platform (a second listing, say) are still included. The token
+// in the URL is the only credential; unknown feeds and bad tokens both 404.
+func (s *Server) handleChannelExportICS(w http.ResponseWriter, r *http.Request) {
+    var id int64; var platform, token string; var private bool
+    err := s.pool.QueryRow(r.Context(), "SELECT id, platform, export_token, export_private FROM icals WHERE id=$1", mux.Vars(r)["feed_id"]).Scan(&id, &platform, &token, &private)
+    if err != nil && !errors.Is(err, pgx.ErrNoRows) { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    if err != nil || subtle.ConstantTimeCompare([]byte(token), []byte(r.URL.Query().Get("token"))) != 1 { jsonResp(w, 404, map[string]string{"error":"not_found"}); return }
+    events, err := s.collectCalendarEvents(r.Context(), id)
+    if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    // Channels get recurring blocks as separate events; not all of them read RRULE.
+    events = expandForFeed(events)
+    if private { events = redactEvents(events) }
+    writeCalendar(w, platform+" Export", events)
+}
+
+// handleRotateExportToken replaces a channel's export token; the old URL stops
+// working immediately and must be updated on the channel.
+func (s *Server) handleRotateExportToken(w http.ResponseWriter, r *http.Request) {
+    if !s.requireOwner(w, r) { return }
+    id := mux.Vars(r)["id"]
+    token := randomToken(24)
+    var feedID int64
+    err := s.pool.QueryRow(r.Context(), "UPDATE icals SET export_token=$2 WHERE id=$1 RETURNING id", id, token).Scan(&feedID)
+    if errors.Is(err, pgx.ErrNoRows) { jsonResp(w, 404, map[string]string{"error":"not_found"}); return }
+    if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    s.audit(r, auditEntry{ Action: "ical.export_token_rotate", TargetType: "ical", TargetID: id })
+    jsonResp(w, 200, map[string]string{"export_path": exportPath(feedID, token)})
+}
+
+func (s *Server) handlePutExportSettings(w http.ResponseWriter, r *http.Request) {
+    if !s.requireOwner(w, r) { return }
+    id := mux.Vars(r)["id"]
+    var body struct{ Private bool }
+    _ = json.NewDecoder(r.Body).Decode(&body)
+    var before bool
+    err := s.pool.QueryRow(r.Context(), "UPDATE icals i SET export_private=$2 FROM icals old WHERE i.id=$1 AND old.id=i.id RETURNING old.export_private", id, body.Private).Scan(&before)
+    if errors.Is(err, pgx.ErrNoRows) { jsonResp(w, 404, map[string]string{"error":"not_found"}); return }
+    if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    s.audit(r, auditEntry{ Action: "ical.export_settings", TargetType: "ical", TargetID: id, Before: map[string]bool{"private": before}, After: map[string]bool{"private": body.Private} })
+    jsonResp(w, 200, map[string]bool{"private": body.Private})
+}
+
+// The owner feed is the detailed calendar (guest names, sources) for an
+// owner's personal calendar app, which can only authenticate with the URL.
+func ownerFeedPath(token string) string { return "/calendar/owner/" + token + ".ics" }
+
+func (s *Server) handleGetOwnerFeed(w http.ResponseWriter, r *http.Request) {
+    if !s.requireOwner(w, r) { return }
+    token := randomToken(24)
+    // Keep an existing token; only create one the first time.
+    if err := s.pool.QueryRow(r.Context(), "UPDATE users SET calendar_token=COALESCE(calendar_token, $2) WHERE email=$1 RETURNING calendar_token", getClaims(r)["email"], token).Scan(&token); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    jsonResp(w, 200, map[string]string{"feed_path": ownerFeedPath(token)})
+}
+
+func (s *Server) handleRotateOwnerFeed(w http.ResponseWriter, r *http.Request) {
+    if !s.requireOwner(w, r) { return }
+    token := randomToken(24)
+    if _, err := s.pool.Exec(r.Context(), "UPDATE users SET calendar_token=$2 WHERE email=$1", getClaims(r)["email"], token); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    s.audit(r, auditEntry{ Action: "calendar.owner_feed_rotate", TargetType: "user", TargetID: getClaims(r)["email"].(string) })
+    jsonResp(w, 200, map[string]string{"feed_path": ownerFeedPath(token)})
+}
+
+func (s *Server) handleOwnerFeedICS(w http.ResponseWriter, r *http.Request) {
+    var isOwner bool
+    err := s.pool.QueryRow(r.Context(), "SELECT COALESCE(is_owner,false) FROM users WHERE calendar_token=$1", mux.Vars(r)["token"]).Scan(&isOwner)
+    if err != nil && !errors.Is(err, pgx.ErrNoRows) { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    if err != nil || !isOwner { jsonResp(w, 404, map[string]string{"error":"not_found"}); return }
+    events, err := s.collectCalendarEvents(r.Context(), 0)
+    if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    writeCalendar(w, "Owner Calendar", events)
+}
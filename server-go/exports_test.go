@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestRedactEvents(t *testing.T) {
+    in := []icsEvent{
+        { UID: "b1", Summary: "Maria Silva (Airbnb HMABC123)", Category: "Booking", Status: "TENTATIVE" },
+        { UID: "b2", Summary: "Bloqueio", Category: "Block", Status: "CONFIRMED" },
+        { UID: "p1", Summary: "Preparation", Category: "Preparation", Status: "CONFIRMED" },
+    }
+    out := redactEvents(in)
+    want := []string{unavailableSummary, unavailableSummary, "Preparation"}
+    for i, e := range out {
+        if e.UID != in[i].UID { t.Errorf("%d: UID = %q, want %q", i, e.UID, in[i].UID) }
+        if e.Summary != want[i] || e.Category != "" || e.Status != "CONFIRMED" { t.Errorf("%d: redacted to %+v", i, e) }
+    }
+    if in[0].Summary != "Maria Silva (Airbnb HMABC123)" { t.Error("input was modified") }
+}
+
+func TestExportPath(t *testing.T) {
+    if got := exportPath(7, "abc"); got != "/calendar/export/7.ics?token=abc" { t.Errorf("exportPath = %q", got) }
+}
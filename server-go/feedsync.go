@@ -0,0 +1,138 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "io"
+    "log"
+    "net/http"
+    "os"
+    "time"
+)
+
+// Imported feeds are fetched in the background and their events stored in
+// ical_events. Everything that reads the calendar uses the stored events, so
+// no request waits on a channel and a feed that fails keeps its last good
+// events. Public answers about free nights refuse to guess once a feed has
+// gone too long without a successful sync.
+
+// maxFeedSize bounds what one feed may send.
+const maxFeedSize = 10 << 20
+
+var errFeedsStale = errors.New("calendar_sync_stale")
+
+// feedSyncInterval is ICAL_SYNC_INTERVAL, default 15 minutes.
+func feedSyncInterval() time.Duration {
+    if d, err := time.ParseDuration(os.Getenv("ICAL_SYNC_INTERVAL")); err == nil && d > 0 { return d }
+    return 15 * time.Minute
+}
+
+// feedMaxAge is ICAL_MAX_AGE, how old a feed's last successful sync may be
+// before availability stops being published. Default four sync intervals.
+func feedMaxAge() time.Duration {
+    if d, err := time.ParseDuration(os.Getenv("ICAL_MAX_AGE")); err == nil && d > 0 { return d }
+    return 4 * feedSyncInterval()
+}
+
+func newFeedClient() *http.Client { return &http.Client{ Timeout: 30 * time.Second } }
+
+// fetchFeed downloads and parses one feed. Cancelled events are dropped and
+// recurrences expanded into instances; only our own blocks are passed on as
+// RRULEs.
+func fetchFeed(ctx context.Context, client *http.Client, platform, url string) ([]icsEvent, error) {
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+    if err != nil { return nil, err }
+    resp, err := client.Do(req)
+    if err != nil { return nil, err }
+    defer resp.Body.Close()
+    if resp.StatusCode >= 300 { return nil, errors.New("unexpected status " + resp.Status) }
+    body, err := io.ReadAll(io.LimitReader(resp.Body, maxFeedSize+1))
+    if err != nil { return nil, err }
+    if len(body) > maxFeedSize { return nil, errors.New("feed too large") }
+    evs := withoutCancelled(parseICS(string(body)))
+    for i := range evs {
+        evs[i].Category = platform
+        if evs[i].UID == "" { evs[i].UID = importedUID(platform, evs[i]) }
+    }
+    return expandForFeed(evs), nil
+}
+
+// syncFeed fetches a feed and replaces its stored events. On failure the
+// stored events are left as they were.
+func (s *Server) syncFeed(ctx context.Context, id int64, platform, url string) error {
+    evs, err := fetchFeed(ctx, s.feedClient, platform, url)
+    if err != nil { s.recordIcalSync(ctx, id, platform, 0, err); return err }
+    tx, err := s.pool.Begin(ctx)
+    if err != nil { return err }
+    defer tx.Rollback(ctx)
+    if _, err := tx.Exec(ctx, "DELETE FROM ical_events WHERE feed_id=$1", id); err != nil { return err }
+    for _, e := range evs {
+        if _, err := tx.Exec(ctx, "INSERT INTO ical_events (feed_id, uid, summary, status, start_date, end_date, stamp, modified, sequence) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)", id, e.UID, e.Summary, e.Status, e.Start, e.End, nullTime(e.Stamp), nullTime(e.Modified), e.Sequence); err != nil { return err }
+    }
+    if _, err := tx.Exec(ctx, "UPDATE icals SET last_success_at=now() WHERE id=$1", id); err != nil { return err }
+    if err := tx.Commit(ctx); err != nil { return err }
+    s.invalidateAvailability()
+    s.recordIcalSync(ctx, id, platform, len(evs), nil)
+    return nil
+}
+
+func nullTime(t time.Time) *time.Time {
+    if t.IsZero() { return nil }
+    return &t
+}
+
+func (s *Server) syncAllFeeds(ctx context.Context) {
+    rows, err := s.pool.Query(ctx, "SELECT id, platform, url FROM icals")
+    if err != nil { log.Println("ical sync:", err); return }
+    type feed struct{ id int64; platform, url string }
+    var feeds []feed
+    for rows.Next() {
+        var f feed
+        if err := rows.Scan(&f.id, &f.platform, &f.url); err != nil { rows.Close(); log.Println("ical sync:", err); return }
+        feeds = append(feeds, f)
+    }
+    rows.Close()
+    for _, f := range feeds {
+        if err := s.syncFeed(ctx, f.id, f.platform, f.url); err != nil { log.Printf("ical sync %s (%d): %v", f.platform, f.id, err) }
+    }
+}
+
+// runFeedSync syncs every feed at startup and then every feedSyncInterval.
+func (s *Server) runFeedSync(ctx context.Context) {
+    t := time.NewTicker(feedSyncInterval())
+    defer t.Stop()
+    for {
+        s.syncAllFeeds(ctx)
+        select {
+        case <-ctx.Done(): return
+        case <-t.C:
+        }
+    }
+}
+
+// storedFeedEvents returns the stored events of every feed except excludeFeed.
+func (s *Server) storedFeedEvents(ctx context.Context, excludeFeed int64) ([]icsEvent, error) {
+    rows, err := s.pool.Query(ctx, "SELECT e.uid, e.summary, e.status, e.start_date, e.end_date, e.stamp, e.modified, e.sequence, i.platform FROM ical_events e JOIN icals i ON i.id = e.feed_id WHERE e.feed_id <> $1 ORDER BY e.feed_id, e.start_date", excludeFeed)
+    if err != nil { return nil, err }
+    defer rows.Close()
+    var out []icsEvent
+    for rows.Next() {
+        var e icsEvent; var stamp, modified *time.Time
+        if err := rows.Scan(&e.UID, &e.Summary, &e.Status, &e.Start, &e.End, &stamp, &modified, &e.Sequence, &e.Category); err != nil { return nil, err }
+        if stamp != nil { e.Stamp = *stamp }
+        if modified != nil { e.Modified = *modified }
+        out = append(out, e)
+    }
+    return out, rows.Err()
+}
+
+// checkFeedsFresh returns errFeedsStale when a feed has never synced or its
+// last successful sync is older than feedMaxAge, since its stored events may
+// no longer show nights the channel has since sold.
+func (s *Server) checkFeedsFresh(ctx context.Context) error {
+    var stale int
+    err := s.pool.QueryRow(ctx, "SELECT COUNT(*) FROM icals WHERE last_success_at IS NULL OR last_success_at < now() - make_interval(secs => $1)", feedMaxAge().Seconds()).Scan(&stale)
+    if err != nil { return err }
+    if stale > 0 { return errFeedsStale }
+    return nil
+}
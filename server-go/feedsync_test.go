@@ -0,0 +1,43 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestFetchFeed(t *testing.T) {
+    const feed = "BEGIN:VCALENDAR\r\n" +
+        "BEGIN:VEVENT\r\nUID:stay\r\nDTSTART;VALUE=DATE:20260110\r\nDTEND;VALUE=DATE:20260113\r\nEND:VEVENT\r\n" +
+        "BEGIN:VEVENT\r\nUID:gone\r\nSTATUS:CANCELLED\r\nDTSTART;VALUE=DATE:20260120\r\nDTEND;VALUE=DATE:20260121\r\nEND:VEVENT\r\n" +
+        "BEGIN:VEVENT\r\nDTSTART;VALUE=DATE:20260201\r\nDTEND;VALUE=DATE:20260202\r\nSUMMARY:no uid\r\nEND:VEVENT\r\n" +
+        "END:VCALENDAR\r\n"
+    mux := http.NewServeMux()
+    mux.HandleFunc("/ok.ics", func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write([]byte(feed)) })
+    mux.HandleFunc("/down.ics", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusBadGateway) })
+    mux.HandleFunc("/slow.ics", func(w http.ResponseWriter, r *http.Request) {
+        select {
+        case <-r.Context().Done():
+        case <-time.After(2 * time.Second):
+        }
+    })
+    srv := httptest.NewServer(mux)
+    defer srv.Close()
+    client := &http.Client{ Timeout: 200 * time.Millisecond }
+    ctx := context.Background()
+
+    evs, err := fetchFeed(ctx, client, "Airbnb", srv.URL+"/ok.ics")
+    if err != nil { t.Fatal(err) }
+    if len(evs) != 2 { t.Fatalf("got %d events, want 2 (cancelled dropped): %+v", len(evs), evs) }
+    if evs[0].UID != "stay" || evs[0].Category != "Airbnb" { t.Errorf("first event = %+v", evs[0]) }
+    if !strings.HasPrefix(evs[1].UID, "import-") { t.Errorf("missing UID not filled in: %q", evs[1].UID) }
+
+    for _, path := range []string{"/down.ics", "/slow.ics"} {
+        start := time.Now()
+        if _, err := fetchFeed(ctx, client, "Airbnb", srv.URL+path); err == nil { t.Errorf("%s: expected an error", path) }
+        if time.Since(start) > time.Second { t.Errorf("%s: took %v, client timeout not applied", path, time.Since(start)) }
+    }
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+    "errors"
+    "net/http"
+    "time"
+)
+
+// subtractRanges removes the merged ranges in b from the merged ranges in a.
+func subtractRanges(a, b []icsEvent) []icsEvent {
+    var out []icsEvent
+    for _, r := range a {
+        for _, x := range b {
+            if !x.End.After(r.Start) || !x.Start.Before(r.End) { continue }
+            if x.Start.After(r.Start) { out = append(out, icsEvent{ Start: r.Start, End: x.Start }) }
+            r.Start = x.End
+            if !r.End.After(r.Start) { break }
+        }
+        if r.End.After(r.Start) { out = append(out, r) }
+    }
+    return out
+}
+
+// freeBusyPeriod formats a date range as a UTC period running from midnight
+// to midnight at the property, as FREEBUSY requires DATE-TIME values.
+func freeBusyPeriod(e icsEvent) string {
+    const layout = "20060102T150405Z"
+    return propertyMidnight(e.Start).UTC().Format(layout) + "/" + propertyMidnight(e.End).UTC().Format(layout)
+}
+
+// buildFreeBusy renders a VFREEBUSY for [from, to). Requested bookings (and
+// imported events marked TENTATIVE) are BUSY-TENTATIVE unless something
+// confirmed covers the same nights.
+func buildFreeBusy(events []icsEvent, from, to time.Time) string {
+    var confirmed, tentative []icsEvent
+    for _, e := range expandEvents(events, from, to) {
+        if e.Status == "TENTATIVE" { tentative = append(tentative, e) } else { confirmed = append(confirmed, e) }
+    }
+    busy := mergeRanges(confirmed, from, to)
+    maybe := subtractRanges(mergeRanges(tentative, from, to), busy)
+    const layout = "20060102T150405Z"
+    var cal icsWriter
+    cal.begin("Free Busy")
+    cal.line("METHOD", "PUBLISH")
+    cal.line("BEGIN", "VFREEBUSY")
+    cal.line("UID", "freebusy-"+from.Format("20060102")+"-"+to.Format("20060102")+"@"+propertyID())
+    cal.line("DTSTAMP", time.Now().UTC().Format(layout))
+    cal.line("DTSTART", propertyMidnight(from).UTC().Format(layout))
+    cal.line("DTEND", propertyMidnight(to).UTC().Format(layout))
+    for _, b := range busy { cal.line("FREEBUSY;FBTYPE=BUSY", freeBusyPeriod(b)) }
+    for _, b := range maybe { cal.line("FREEBUSY;FBTYPE=BUSY-TENTATIVE", freeBusyPeriod(b)) }
+    cal.line("END", "VFREEBUSY")
+    cal.end()
+    return cal.String()
+}
+
+// handleFreeBusyICS publishes free/busy time for partners that consume
+// VFREEBUSY instead of event feeds. Like /availability it is public, carries
+// no guest or source details, takes the same from/to window and answers 503
+// rather than showing free time while an imported feed is stale.
+func (s *Server) handleFreeBusyICS(w http.ResponseWriter, r *http.Request) {
+    from, to, code := availabilityWindow(r)
+    if code != "" { jsonResp(w, 400, map[string]string{"error": code}); return }
+    if err := s.checkFeedsFresh(r.Context()); err != nil {
+        if errors.Is(err, errFeedsStale) { writeFeedsStale(w); return }
+        jsonResp(w, 500, map[string]string{"error": err.Error()}); return
+    }
+    key := "freebusy|" + from.Format(dateLayout) + "|" + to.Format(dateLayout)
+    body, ok := s.availability.get(key).(string)
+    if !ok {
+        events, err := s.collectCalendarEvents(r.Context(), 0)
+        if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+        body = buildFreeBusy(events, from, to)
+        s.availability.put(key, body)
+    }
+    w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+    w.Header().Set("Cache-Control", "public, max-age=60")
+    _, _ = w.Write([]byte(body))
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestSubtractRanges(t *testing.T) {
+    tests := []struct {
+        name string
+        a, b []string
+        want []string
+    }{
+        {"nothing to remove", []string{"2026-01-10/2026-01-15"}, nil, []string{"2026-01-10/2026-01-15"}},
+        {"disjoint", []string{"2026-01-10/2026-01-15"}, []string{"2026-01-20/2026-01-22"}, []string{"2026-01-10/2026-01-15"}},
+        {"adjacent", []string{"2026-01-10/2026-01-15"}, []string{"2026-01-15/2026-01-17"}, []string{"2026-01-10/2026-01-15"}},
+        {"head", []string{"2026-01-10/2026-01-15"}, []string{"2026-01-08/2026-01-12"}, []string{"2026-01-12/2026-01-15"}},
+        {"tail", []string{"2026-01-10/2026-01-15"}, []string{"2026-01-13/2026-01-20"}, []string{"2026-01-10/2026-01-13"}},
+        {"middle splits", []string{"2026-01-10/2026-01-20"}, []string{"2026-01-12/2026-01-14", "2026-01-16/2026-01-17"}, []string{"2026-01-10/2026-01-12", "2026-01-14/2026-01-16", "2026-01-17/2026-01-20"}},
+        {"covered", []string{"2026-01-10/2026-01-15"}, []string{"2026-01-01/2026-01-31"}, nil},
+        {"several ranges", []string{"2026-01-10/2026-01-12", "2026-01-20/2026-01-25"}, []string{"2026-01-11/2026-01-21"}, []string{"2026-01-10/2026-01-11", "2026-01-21/2026-01-25"}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got := formatSpans(subtractRanges(spans(t, tt.a...), spans(t, tt.b...)))
+            if !sameSpans(got, tt.want) { t.Errorf("subtractRanges = %v, want %v", got, tt.want) }
+        })
+    }
+}
+
+func TestBuildFreeBusy(t *testing.T) {
+    events := []icsEvent{
+        { UID: "a", Status: "CONFIRMED", Start: mustDate(t, "2026-01-10"), End: mustDate(t, "2026-01-13") },
+        { UID: "b", Status: "TENTATIVE", Start: mustDate(t, "2026-01-12"), End: mustDate(t, "2026-01-15") },
+    }
+    out := buildFreeBusy(events, mustDate(t, "2026-01-01"), mustDate(t, "2026-02-01"))
+    for _, want := range []string{"FREEBUSY;FBTYPE=BUSY:20260110T030000Z/20260113T030000Z", "FREEBUSY;FBTYPE=BUSY-TENTATIVE:20260113T030000Z/20260115T030000Z"} {
+        if !strings.Contains(out, want) { t.Errorf("missing %q in\n%s", want, out) }
+    }
+}
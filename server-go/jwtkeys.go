@@ -0,0 +1,91 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "log"
+    "os"
+    "strings"
+    "time"
+    "github.com/golang-jwt/jwt/v5"
+)
+
+const minJWTSecretLen = 32
+
+// JWTKeyring holds every HMAC key that may still verify tokens. New tokens are
+// signed with the active key; the rest stay valid until their tokens expire,
+// which lets secrets rotate without logging everyone out.
+type JWTKeyring struct {
+    keys map[string][]byte
+    active string
+    issuer string
+    audience string
+}
+
+func isProduction() bool {
+    env := strings.ToLower(os.Getenv("APP_ENV"))
+    return env == "production" || env == "prod"
+}
+
+// loadJWTKeyring reads JWT_KEYS ("kid:secret,kid:secret") and JWT_ACTIVE_KID,
+// falling back to a single JWT_SECRET. Outside production a missing secret
+// falls back to a development key; in production it is an error.
+func loadJWTKeyring() (*JWTKeyring, error) {
+    kr := &JWTKeyring{ keys: make(map[string][]byte), issuer: os.Getenv("JWT_ISSUER"), audience: os.Getenv("JWT_AUDIENCE") }
+    if kr.issuer == "" { kr.issuer = "ocean-haven" }
+    if kr.audience == "" { kr.audience = "ocean-haven-api" }
+    for _, pair := range strings.Split(os.Getenv("JWT_KEYS"), ",") {
+        pair = strings.TrimSpace(pair)
+        if pair == "" { continue }
+        kid, secret, ok := strings.Cut(pair, ":")
+        if !ok || kid == "" || secret == "" { return nil, fmt.Errorf("JWT_KEYS: malformed entry %q", kid) }
+        if _, dup := kr.keys[kid]; dup { return nil, fmt.Errorf("JWT_KEYS: duplicate kid %q", kid) }
+        kr.keys[kid] = []byte(secret)
+        if kr.active == "" { kr.active = kid }
+    }
+    if len(kr.keys) == 0 {
+        if secret := os.Getenv("JWT_SECRET"); secret != "" {
+            kr.keys["default"] = []byte(secret)
+            kr.active = "default"
+        }
+    }
+    if active := os.Getenv("JWT_ACTIVE_KID"); active != "" {
+        if _, ok := kr.keys[active]; !ok { return nil, fmt.Errorf("JWT_ACTIVE_KID %q is not in JWT_KEYS", active) }
+        kr.active = active
+    }
+    if len(kr.keys) == 0 {
+        if isProduction() { return nil, errors.New("JWT_SECRET or JWT_KEYS must be set in production") }
+        log.Println("AVISO: JWT_SECRET não definido, usando chave de desenvolvimento")
+        kr.keys["dev"] = []byte("dev-secret")
+        kr.active = "dev"
+    }
+    if isProduction() {
+        for kid, k := range kr.keys {
+            if len(k) < minJWTSecretLen || string(k) == "dev-secret" { return nil, fmt.Errorf("JWT key %q is too weak for production (min %d bytes)", kid, minJWTSecretLen) }
+        }
+    }
+    return kr, nil
+}
+
+func (kr *JWTKeyring) Sign(claims jwt.MapClaims) (string, error) {
+    claims["iss"] = kr.issuer
+    claims["aud"] = kr.audience
+    token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+    token.Header["kid"] = kr.active
+    return token.SignedString(kr.keys[kr.active])
+}
+
+// Parse only accepts HS256 tokens naming a known kid, with our issuer and
+// audience and an expiry.
+func (kr *JWTKeyring) Parse(tokenStr string) (jwt.MapClaims, error) {
+    tkn, err := jwt.Parse(tokenStr, func(token *jwt.Token) (any, error) {
+        kid, _ := token.Header["kid"].(string)
+        key, ok := kr.keys[kid]
+        if !ok { return nil, errors.New("unknown kid") }
+        return key, nil
+    }, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithIssuer(kr.issuer), jwt.WithAudience(kr.audience), jwt.WithExpirationRequired(), jwt.WithIssuedAt(), jwt.WithLeeway(30*time.Second))
+    if err != nil || !tkn.Valid { return nil, errors.New("invalid_token") }
+    claims, ok := tkn.Claims.(jwt.MapClaims)
+    if !ok { return nil, errors.New("invalid_token") }
+    return claims, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+    "strings"
+    "testing"
+    "time"
+    "github.com/golang-jwt/jwt/v5"
+)
+
+func testKeyring(t *testing.T, keys, active string) *JWTKeyring {
+    t.Helper()
+    t.Setenv("APP_ENV", "")
+    t.Setenv("JWT_SECRET", "")
+    t.Setenv("JWT_KEYS", keys)
+    t.Setenv("JWT_ACTIVE_KID", active)
+    kr, err := loadJWTKeyring()
+    if err != nil { t.Fatal(err) }
+    return kr
+}
+
+func testClaims() jwt.MapClaims {
+    now := time.Now()
+    return jwt.MapClaims{"email": "o@example.com", "iat": now.Unix(), "exp": now.Add(time.Minute).Unix()}
+}
+
+func TestJWTKeyRotation(t *testing.T) {
+    old := testKeyring(t, "k1:first-secret", "")
+    tok, err := old.Sign(testClaims())
+    if err != nil { t.Fatal(err) }
+    rotated := testKeyring(t, "k1:first-secret,k2:second-secret", "k2")
+    if _, err := rotated.Parse(tok); err != nil { t.Errorf("token signed with the old key stopped verifying: %v", err) }
+    fresh, err := rotated.Sign(testClaims())
+    if err != nil { t.Fatal(err) }
+    if _, err := old.Parse(fresh); err == nil { t.Error("keyring without k2 accepted a k2 token") }
+    retired := testKeyring(t, "k2:second-secret", "")
+    if _, err := retired.Parse(tok); err == nil { t.Error("token for a retired kid still verifies") }
+}
+
+func TestJWTParseRejects(t *testing.T) {
+    kr := testKeyring(t, "k1:first-secret", "")
+    sign := func(method jwt.SigningMethod, claims jwt.MapClaims, kid string, key any) string {
+        tok := jwt.NewWithClaims(method, claims)
+        tok.Header["kid"] = kid
+        s, err := tok.SignedString(key)
+        if err != nil { t.Fatal(err) }
+        return s
+    }
+    base := func(edit func(jwt.MapClaims)) jwt.MapClaims {
+        c := testClaims()
+        c["iss"], c["aud"] = "ocean-haven", "ocean-haven-api"
+        if edit != nil { edit(c) }
+        return c
+    }
+    key := []byte("first-secret")
+    tests := []struct{ name, token string }{
+        {"alg none", sign(jwt.SigningMethodNone, base(nil), "k1", jwt.UnsafeAllowNoneSignatureType)},
+        {"HS512", sign(jwt.SigningMethodHS512, base(nil), "k1", key)},
+        {"unknown kid", sign(jwt.SigningMethodHS256, base(nil), "k9", key)},
+        {"wrong issuer", sign(jwt.SigningMethodHS256, base(func(c jwt.MapClaims) { c["iss"] = "other" }), "k1", key)},
+        {"wrong audience", sign(jwt.SigningMethodHS256, base(func(c jwt.MapClaims) { c["aud"] = "other" }), "k1", key)},
+        {"no expiry", sign(jwt.SigningMethodHS256, base(func(c jwt.MapClaims) { delete(c, "exp") }), "k1", key)},
+        {"expired", sign(jwt.SigningMethodHS256, base(func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }), "k1", key)},
+    }
+    for _, tt := range tests {
+        if _, err := kr.Parse(tt.token); err == nil { t.Errorf("%s: token accepted", tt.name) }
+    }
+    if _, err := kr.Parse(sign(jwt.SigningMethodHS256, base(nil), "k1", key)); err != nil { t.Errorf("valid token rejected: %v", err) }
+}
+
+func TestLoadJWTKeyringProduction(t *testing.T) {
+    t.Setenv("APP_ENV", "production")
+    t.Setenv("JWT_KEYS", "")
+    t.Setenv("JWT_ACTIVE_KID", "")
+    t.Setenv("JWT_SECRET", "")
+    if _, err := loadJWTKeyring(); err == nil { t.Error("missing secret accepted in production") }
+    t.Setenv("JWT_SECRET", "short")
+    if _, err := loadJWTKeyring(); err == nil || !strings.Contains(err.Error(), "too weak") { t.Errorf("short secret: err = %v", err) }
+    t.Setenv("JWT_SECRET", strings.Repeat("x", minJWTSecretLen))
+    if _, err := loadJWTKeyring(); err != nil { t.Errorf("strong secret rejected: %v", err) }
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "testing"
+    "github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestIsInvalidData(t *testing.T) {
+    tests := []struct{ err error; want bool }{
+        {&pgconn.PgError{ Code: "23514" }, true},
+        {&pgconn.PgError{ Code: "23502" }, true},
+        {&pgconn.PgError{ Code: "23503" }, true},
+        {&pgconn.PgError{ Code: "22007" }, true},
+        {fmt.Errorf("insert booking: %w", &pgconn.PgError{ Code: "22008" }), true},
+        {&pgconn.PgError{ Code: "23505" }, false},
+        {&pgconn.PgError{ Code: "40001" }, false},
+        {errors.New("connection refused"), false},
+    }
+    for _, tt := range tests {
+        if got := isInvalidData(tt.err); got != tt.want { t.Errorf("isInvalidData(%v) = %v, want %v", tt.err, got, tt.want) }
+    }
+}
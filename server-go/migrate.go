@@ -0,0 +1,156 @@
+package main
+
+import (
+    "context"
+    "embed"
+    "fmt"
+    "io/fs"
+    "log"
+    "path"
+    "sort"
+    "strconv"
+    "strings"
+    "github.com/jackc/pgx/v5/pgxpool"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrateLockID serializes migrations across instances starting at the same time.
+const migrateLockID = 73310038
+
+type migration struct {
+    Version int
+    Name string
+    Up string
+    Down string
+}
+
+// loadMigrations reads migrations/NNNN_name.up.sql and the matching
+// .down.sql files, ordered by version.
+func loadMigrations() ([]migration, error) {
+    entries, err := fs.ReadDir(migrationFiles, "migrations")
+    if err != nil { return nil, err }
+    byVersion := map[int]*migration{}
+    for _, e := range entries {
+        name := e.Name()
+        base, dir := strings.TrimSuffix(name, ".sql"), ""
+        switch {
+        case strings.HasSuffix(base, ".up"): dir, base = "up", strings.TrimSuffix(base, ".up")
+        case strings.HasSuffix(base, ".down"): dir, base = "down", strings.TrimSuffix(base, ".down")
+        default: return nil, fmt.Errorf("migration %s: expected .up.sql or .down.sql", name)
+        }
+        num, label, _ := strings.Cut(base, "_")
+        v, err := strconv.Atoi(num)
+        if err != nil || v <= 0 { return nil, fmt.Errorf("migration %s: bad version", name) }
+        body, err := migrationFiles.ReadFile(path.Join("migrations", name))
+        if err != nil { return nil, err }
+        m := byVersion[v]
+        if m == nil { m = &migration{ Version: v, Name: label }; byVersion[v] = m }
+        if m.Name != label { return nil, fmt.Errorf("migration %d has two names: %s, %s", v, m.Name, label) }
+        if dir == "up" { m.Up = string(body) } else { m.Down = string(body) }
+    }
+    out := make([]migration, 0, len(byVersion))
+    for _, m := range byVersion {
+        if m.Up == "" { return nil, fmt.Errorf("migration %d_%s has no up file", m.Version, m.Name) }
+        out = append(out, *m)
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+    return out, nil
+}
+
+// withMigrationLock runs fn holding the migration advisory lock, with
+// schema_migrations created and the applied versions loaded.
+func withMigrationLock(ctx context.Context, pool *pgxpool.Pool, fn func(conn *pgxpool.Conn, applied map[int]bool) error) error {
+    conn, err := pool.Acquire(ctx)
+    if err != nil { return err }
+    defer conn.Release()
+    if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", migrateLockID); err != nil { return err }
+    defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", migrateLockID)
+    if _, err := conn.Exec(ctx, "CREATE TABLE IF NOT EXISTS schema_migrations (version INT PRIMARY KEY, name TEXT NOT NULL, applied_at TIMESTAMPTZ NOT NULL DEFAULT now())"); err != nil { return err }
+    rows, err := conn.Query(ctx, "SELECT version FROM schema_migrations")
+    if err != nil { return err }
+    applied := map[int]bool{}
+    for rows.Next() {
+        var v int
+        if err := rows.Scan(&v); err != nil { rows.Close(); return err }
+        applied[v] = true
+    }
+    rows.Close()
+    if rows.Err() != nil { return rows.Err() }
+    return fn(conn, applied)
+}
+
+// migrateUp applies every pending migration up to target (0 means all), each
+// in its own transaction together with its schema_migrations row.
+func migrateUp(ctx context.Context, pool *pgxpool.Pool, target int) error {
+    ms, err := loadMigrations()
+    if err != nil { return err }
+    return withMigrationLock(ctx, pool, func(conn *pgxpool.Conn, applied map[int]bool) error {
+        for _, m := range ms {
+            if applied[m.Version] || (target > 0 && m.Version > target) { continue }
+            tx, err := conn.Begin(ctx)
+            if err != nil { return err }
+            if _, err := tx.Exec(ctx, m.Up); err != nil { tx.Rollback(ctx); return fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err) }
+            if _, err := tx.Exec(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1,$2)", m.Version, m.Name); err != nil { tx.Rollback(ctx); return err }
+            if err := tx.Commit(ctx); err != nil { return err }
+            log.Printf("migração aplicada: %04d_%s", m.Version, m.Name)
+        }
+        return nil
+    })
+}
+
+// migrateDown reverts the last steps applied migrations, newest first.
+func migrateDown(ctx context.Context, pool *pgxpool.Pool, steps int) error {
+    ms, err := loadMigrations()
+    if err != nil { return err }
+    return withMigrationLock(ctx, pool, func(conn *pgxpool.Conn, applied map[int]bool) error {
+        for i := len(ms) - 1; i >= 0 && steps > 0; i-- {
+            m := ms[i]
+            if !applied[m.Version] { continue }
+            if m.Down == "" { return fmt.Errorf("migration %d_%s has no down file", m.Version, m.Name) }
+            tx, err := conn.Begin(ctx)
+            if err != nil { return err }
+            if _, err := tx.Exec(ctx, m.Down); err != nil { tx.Rollback(ctx); return fmt.Errorf("migration %d_%s down: %w", m.Version, m.Name, err) }
+            if _, err := tx.Exec(ctx, "DELETE FROM schema_migrations WHERE version=$1", m.Version); err != nil { tx.Rollback(ctx); return err }
+            if err := tx.Commit(ctx); err != nil { return err }
+            log.Printf("migração revertida: %04d_%s", m.Version, m.Name)
+            steps--
+        }
+        return nil
+    })
+}
+
+func migrateStatus(ctx context.Context, pool *pgxpool.Pool) error {
+    ms, err := loadMigrations()
+    if err != nil { return err }
+    return withMigrationLock(ctx, pool, func(conn *pgxpool.Conn, applied map[int]bool) error {
+        for _, m := range ms {
+            state := "pendente"
+            if applied[m.Version] { state = "aplicada" }
+            fmt.Printf("%04d_%s\t%s\n", m.Version, m.Name, state)
+        }
+        return nil
+    })
+}
+
+// runMigrateCommand handles `server migrate up [version]`, `migrate down [steps]`
+// and `migrate status`.
+func runMigrateCommand(ctx context.Context, pool *pgxpool.Pool, args []string) error {
+    cmd := "up"
+    if len(args) > 0 { cmd = args[0] }
+    n := 0
+    if len(args) > 1 {
+        v, err := strconv.Atoi(args[1])
+        if err != nil || v < 0 { return fmt.Errorf("migrate %s: invalid number %q", cmd, args[1]) }
+        n = v
+    }
+    switch cmd {
+    case "up": return migrateUp(ctx, pool, n)
+    case "down":
+        if n == 0 { n = 1 }
+        return migrateDown(ctx, pool, n)
+    case "status": return migrateStatus(ctx, pool)
+    }
+    return fmt.Errorf("usage: migrate [up [version] | down [steps] | status]")
+}
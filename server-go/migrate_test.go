@@ -0,0 +1,13 @@
+package main
+
+import "testing"
+
+func TestLoadMigrations(t *testing.T) {
+    ms, err := loadMigrations()
+    if err != nil { t.Fatal(err) }
+    if len(ms) == 0 { t.Fatal("no migrations embedded") }
+    for i, m := range ms {
+        if m.Version != i+1 { t.Errorf("migration %d_%s: want version %d, versions must have no gaps", m.Version, m.Name, i+1) }
+        if m.Down == "" { t.Errorf("migration %d_%s has no down file", m.Version, m.Name) }
+    }
+}
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS bookings;
+DROP TABLE IF EXISTS blocks;
+DROP TABLE IF EXISTS icals;
+DROP TABLE IF EXISTS users;
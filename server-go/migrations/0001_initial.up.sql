@@ -0,0 +1,47 @@
+-- Tables that predate versioned migrations. IF NOT EXISTS lets databases
+-- created by the old ensureSchema adopt this history without changes.
+CREATE EXTENSION IF NOT EXISTS pgcrypto;
+CREATE TABLE IF NOT EXISTS users (
+  email TEXT PRIMARY KEY,
+  password_hash TEXT NOT NULL,
+  full_name TEXT,
+  is_owner BOOLEAN DEFAULT FALSE,
+  created_at TIMESTAMP DEFAULT now()
+);
+CREATE TABLE IF NOT EXISTS icals (
+  id SERIAL PRIMARY KEY,
+  platform TEXT NOT NULL,
+  url TEXT NOT NULL,
+  created_at TIMESTAMP DEFAULT now()
+);
+CREATE TABLE IF NOT EXISTS blocks (
+  id SERIAL PRIMARY KEY,
+  from_ts TIMESTAMP NOT NULL,
+  to_ts TIMESTAMP NOT NULL,
+  note TEXT,
+  created_at TIMESTAMP DEFAULT now()
+);
+CREATE TABLE IF NOT EXISTS bookings (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_email TEXT,
+  status TEXT,
+  check_in TIMESTAMP,
+  check_out TIMESTAMP,
+  guest_name TEXT,
+  guest_email TEXT,
+  guest_phone TEXT,
+  number_of_guests INT,
+  total_price NUMERIC,
+  created_at TIMESTAMP DEFAULT now(),
+  updated_at TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS messages (
+  id SERIAL PRIMARY KEY,
+  booking_id UUID,
+  sender_email TEXT,
+  is_from_owner BOOLEAN,
+  message TEXT,
+  created_at TIMESTAMP DEFAULT now()
+);
+ALTER TABLE bookings ADD COLUMN IF NOT EXISTS subtotal_price NUMERIC;
+ALTER TABLE bookings ADD COLUMN IF NOT EXISTS discount_amount NUMERIC;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS notification_optouts;
+DROP TABLE IF EXISTS notification_prefs;
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS notification_prefs (
+  user_email TEXT PRIMARY KEY,
+  phone TEXT,
+  channels TEXT[] NOT NULL DEFAULT '{email,whatsapp}',
+  opted_out BOOLEAN NOT NULL DEFAULT FALSE,
+  updated_at TIMESTAMP DEFAULT now()
+);
+CREATE TABLE IF NOT EXISTS notification_optouts (
+  phone TEXT NOT NULL,
+  channel TEXT NOT NULL,
+  created_at TIMESTAMP DEFAULT now(),
+  PRIMARY KEY (phone, channel)
+);
@@ -0,0 +1,6 @@
+ALTER TABLE icals DROP COLUMN IF EXISTS last_event_count;
+ALTER TABLE icals DROP COLUMN IF EXISTS last_sync_error;
+ALTER TABLE icals DROP COLUMN IF EXISTS last_synced_at;
+DROP TABLE IF EXISTS webhook_delivery_attempts;
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhooks;
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS sessions (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_email TEXT NOT NULL,
+  refresh_hash TEXT NOT NULL,
+  previous_refresh_hash TEXT,
+  user_agent TEXT,
+  ip TEXT,
+  created_at TIMESTAMP DEFAULT now(),
+  last_used_at TIMESTAMP,
+  expires_at TIMESTAMP NOT NULL,
+  revoked_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS sessions_user_email_idx ON sessions (user_email);
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+  jti TEXT PRIMARY KEY,
+  expires_at TIMESTAMP NOT NULL
+);
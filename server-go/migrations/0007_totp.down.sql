@@ -0,0 +1,6 @@
+ALTER TABLE users DROP COLUMN IF EXISTS totp_last_step;
+ALTER TABLE users DROP COLUMN IF EXISTS totp_enabled;
+ALTER TABLE users DROP COLUMN IF EXISTS totp_secret;
+DROP TABLE IF EXISTS app_settings;
+DROP TABLE IF EXISTS totp_recovery_codes;
+DROP TABLE IF EXISTS mfa_challenges;
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS oidc_states (
+  state TEXT PRIMARY KEY,
+  nonce TEXT NOT NULL,
+  code_verifier TEXT NOT NULL,
+  expires_at TIMESTAMP NOT NULL
+);
+CREATE TABLE IF NOT EXISTS user_identities (
+  provider TEXT NOT NULL,
+  subject TEXT NOT NULL,
+  user_email TEXT NOT NULL,
+  created_at TIMESTAMP DEFAULT now(),
+  PRIMARY KEY (provider, subject)
+);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS retention_runs (
+  id BIGSERIAL PRIMARY KEY,
+  triggered_by TEXT NOT NULL,
+  policy JSONB NOT NULL,
+  booking_ids UUID[] NOT NULL DEFAULT '{}',
+  messages_deleted BIGINT NOT NULL DEFAULT 0,
+  created_at TIMESTAMP DEFAULT now()
+);
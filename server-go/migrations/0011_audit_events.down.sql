@@ -0,0 +1,8 @@
+GRANT audit_owner TO CURRENT_USER;
+DROP FUNCTION IF EXISTS audit_redact_before(TIMESTAMPTZ);
+DROP FUNCTION IF EXISTS audit_redact_subject(TEXT[]);
+DROP TABLE IF EXISTS audit_events;
+DROP FUNCTION IF EXISTS audit_events_append_only();
+REVOKE USAGE, CREATE ON SCHEMA public FROM audit_owner;
+REVOKE audit_owner FROM CURRENT_USER;
+ALTER TABLE retention_runs DROP COLUMN IF EXISTS audit_events_redacted;
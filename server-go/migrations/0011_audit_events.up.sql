@@ -0,0 +1,102 @@
+CREATE TABLE IF NOT EXISTS audit_events (
+  id BIGSERIAL PRIMARY KEY,
+  actor TEXT,
+  actor_type TEXT NOT NULL,
+  action TEXT NOT NULL,
+  target_type TEXT,
+  target_id TEXT,
+  before JSONB,
+  after JSONB,
+  ip TEXT,
+  user_agent TEXT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  redacted_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS audit_events_target_idx ON audit_events (target_type, target_id);
+CREATE INDEX IF NOT EXISTS audit_events_actor_idx ON audit_events (actor, created_at);
+ALTER TABLE retention_runs ADD COLUMN IF NOT EXISTS audit_events_redacted BIGINT NOT NULL DEFAULT 0;
+-- The log belongs to audit_owner, a role nobody logs in as. The app role may
+-- only read and append; personal data is cleared by the redaction functions
+-- below, which run as audit_owner. Creating the role needs CREATEROLE, or a
+-- DBA can create it beforehand and grant it to the app role.
+DO $$
+BEGIN
+  IF NOT EXISTS (SELECT 1 FROM pg_roles WHERE rolname = 'audit_owner') THEN
+    CREATE ROLE audit_owner NOLOGIN;
+  END IF;
+END
+$$;
+GRANT audit_owner TO CURRENT_USER;
+GRANT USAGE, CREATE ON SCHEMA public TO audit_owner;
+-- Redaction may clear personal data and nothing else about an event.
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+  IF TG_OP = 'UPDATE' AND current_user = 'audit_owner'
+     AND NEW.id = OLD.id AND NEW.actor_type = OLD.actor_type AND NEW.action = OLD.action
+     AND NEW.target_type IS NOT DISTINCT FROM OLD.target_type AND NEW.created_at = OLD.created_at
+     AND (NEW.actor IS NULL OR NEW.actor = OLD.actor)
+     AND (NEW.target_id IS NULL OR NEW.target_id = OLD.target_id)
+     AND (NEW.before IS NULL OR NEW.before = OLD.before)
+     AND (NEW.after IS NULL OR NEW.after = OLD.after)
+     AND (NEW.ip IS NULL OR NEW.ip = OLD.ip)
+     AND (NEW.user_agent IS NULL OR NEW.user_agent = OLD.user_agent) THEN
+    RETURN NEW;
+  END IF;
+  RAISE EXCEPTION 'audit_events is append-only';
+END
+$$ LANGUAGE plpgsql;
+DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events;
+CREATE TRIGGER audit_events_append_only BEFORE UPDATE OR DELETE ON audit_events FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
+-- audit_redact_subject erases a data subject from the log: their actor
+-- entries lose the actor, IP and user agent, and events about them lose the
+-- target and the before/after snapshots. subjects holds the pseudonym and,
+-- for events written before pseudonyms, the raw email.
+CREATE OR REPLACE FUNCTION audit_redact_subject(subjects TEXT[]) RETURNS BIGINT
+LANGUAGE plpgsql SECURITY DEFINER SET search_path = public AS $$
+DECLARE n BIGINT;
+BEGIN
+  UPDATE audit_events SET
+    actor = CASE WHEN actor = ANY(subjects) THEN NULL ELSE actor END,
+    ip = CASE WHEN actor = ANY(subjects) THEN NULL ELSE ip END,
+    user_agent = CASE WHEN actor = ANY(subjects) THEN NULL ELSE user_agent END,
+    target_id = CASE WHEN target_type = 'user' AND target_id = ANY(subjects) THEN NULL ELSE target_id END,
+    before = CASE WHEN target_type = 'user' AND target_id = ANY(subjects) THEN NULL ELSE before END,
+    after = CASE WHEN target_type = 'user' AND target_id = ANY(subjects) THEN NULL ELSE after END,
+    redacted_at = now()
+  WHERE actor = ANY(subjects) OR (target_type = 'user' AND target_id = ANY(subjects));
+  GET DIAGNOSTICS n = ROW_COUNT;
+  RETURN n;
+END
+$$;
+-- audit_redact_before is the retention rule: events older than cutoff keep
+-- what happened and to which record, but no longer who did it from where,
+-- nor snapshots of user records. System actors are kept.
+CREATE OR REPLACE FUNCTION audit_redact_before(cutoff TIMESTAMPTZ) RETURNS BIGINT
+LANGUAGE plpgsql SECURITY DEFINER SET search_path = public AS $$
+DECLARE n BIGINT;
+BEGIN
+  UPDATE audit_events SET
+    actor = CASE WHEN actor_type = 'system' THEN actor ELSE NULL END,
+    ip = NULL,
+    user_agent = NULL,
+    target_id = CASE WHEN target_type = 'user' THEN NULL ELSE target_id END,
+    before = CASE WHEN target_type = 'user' THEN NULL ELSE before END,
+    after = CASE WHEN target_type = 'user' THEN NULL ELSE after END,
+    redacted_at = now()
+  WHERE created_at < cutoff AND redacted_at IS NULL;
+  GET DIAGNOSTICS n = ROW_COUNT;
+  RETURN n;
+END
+$$;
+ALTER TABLE audit_events OWNER TO audit_owner;
+ALTER FUNCTION audit_redact_subject(TEXT[]) OWNER TO audit_owner;
+ALTER FUNCTION audit_redact_before(TIMESTAMPTZ) OWNER TO audit_owner;
+REVOKE ALL ON audit_events FROM PUBLIC;
+REVOKE ALL ON audit_events FROM CURRENT_USER;
+GRANT SELECT, INSERT ON audit_events TO CURRENT_USER;
+GRANT USAGE ON SEQUENCE audit_events_id_seq TO CURRENT_USER;
+REVOKE ALL ON FUNCTION audit_redact_subject(TEXT[]) FROM PUBLIC;
+REVOKE ALL ON FUNCTION audit_redact_before(TIMESTAMPTZ) FROM PUBLIC;
+GRANT EXECUTE ON FUNCTION audit_redact_subject(TEXT[]) TO CURRENT_USER;
+GRANT EXECUTE ON FUNCTION audit_redact_before(TIMESTAMPTZ) TO CURRENT_USER;
+REVOKE audit_owner FROM CURRENT_USER;
@@ -0,0 +1,20 @@
+-- Quarantined rows are kept; restore them by hand from migration_quarantine if needed.
+DROP INDEX IF EXISTS messages_booking_id_created_at_idx;
+DROP INDEX IF EXISTS bookings_status_check_in_idx;
+DROP INDEX IF EXISTS bookings_user_email_idx;
+ALTER TABLE messages DROP CONSTRAINT IF EXISTS messages_booking_id_fkey;
+ALTER TABLE bookings
+  DROP CONSTRAINT IF EXISTS bookings_user_email_fkey,
+  DROP CONSTRAINT IF EXISTS bookings_guests_check,
+  DROP CONSTRAINT IF EXISTS bookings_dates_check,
+  DROP CONSTRAINT IF EXISTS bookings_status_check,
+  ALTER COLUMN created_at DROP NOT NULL,
+  ALTER COLUMN number_of_guests DROP NOT NULL,
+  ALTER COLUMN number_of_guests DROP DEFAULT,
+  ALTER COLUMN guest_name DROP NOT NULL,
+  ALTER COLUMN status DROP NOT NULL,
+  ALTER COLUMN status DROP DEFAULT,
+  ALTER COLUMN check_out DROP NOT NULL,
+  ALTER COLUMN check_in DROP NOT NULL,
+  ALTER COLUMN check_out TYPE TIMESTAMP USING (check_out::timestamp AT TIME ZONE 'America/Sao_Paulo' AT TIME ZONE 'UTC'),
+  ALTER COLUMN check_in TYPE TIMESTAMP USING (check_in::timestamp AT TIME ZONE 'America/Sao_Paulo' AT TIME ZONE 'UTC');
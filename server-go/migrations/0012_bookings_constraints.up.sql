@@ -0,0 +1,57 @@
+-- Rows that can't satisfy the new constraints are copied here before being
+-- removed, so nothing is lost silently.
+CREATE TABLE IF NOT EXISTS migration_quarantine (
+  id BIGSERIAL PRIMARY KEY,
+  source_table TEXT NOT NULL,
+  reason TEXT NOT NULL,
+  row_data JSONB NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Data cleanup. Stay dates are compared in the property time zone, the same
+-- conversion the columns get below.
+UPDATE bookings SET status = lower(trim(status)) WHERE status IS NOT NULL AND status <> lower(trim(status));
+UPDATE bookings SET status = 'requested' WHERE status IS NULL OR status NOT IN ('requested', 'approved', 'rejected');
+UPDATE bookings SET guest_name = '' WHERE guest_name IS NULL;
+UPDATE bookings SET number_of_guests = 1 WHERE number_of_guests IS NULL OR number_of_guests < 1;
+UPDATE bookings SET created_at = COALESCE(updated_at, now()) WHERE created_at IS NULL;
+UPDATE bookings b SET user_email = NULL WHERE user_email IS NOT NULL AND NOT EXISTS (SELECT 1 FROM users u WHERE u.email = b.user_email);
+WITH bad AS (
+  SELECT id, CASE WHEN check_in IS NULL OR check_out IS NULL THEN 'missing stay dates' ELSE 'check_out not after check_in' END AS reason
+  FROM bookings
+  WHERE check_in IS NULL OR check_out IS NULL OR (check_out AT TIME ZONE 'UTC' AT TIME ZONE 'America/Sao_Paulo')::date <= (check_in AT TIME ZONE 'UTC' AT TIME ZONE 'America/Sao_Paulo')::date
+)
+INSERT INTO migration_quarantine (source_table, reason, row_data)
+SELECT 'messages', 'booking quarantined: ' || bad.reason, to_jsonb(m) FROM messages m JOIN bad ON bad.id = m.booking_id
+UNION ALL
+SELECT 'bookings', bad.reason, to_jsonb(b) FROM bookings b JOIN bad ON bad.id = b.id;
+DELETE FROM messages WHERE booking_id IN (SELECT id FROM bookings WHERE check_in IS NULL OR check_out IS NULL OR (check_out AT TIME ZONE 'UTC' AT TIME ZONE 'America/Sao_Paulo')::date <= (check_in AT TIME ZONE 'UTC' AT TIME ZONE 'America/Sao_Paulo')::date);
+DELETE FROM bookings WHERE check_in IS NULL OR check_out IS NULL OR (check_out AT TIME ZONE 'UTC' AT TIME ZONE 'America/Sao_Paulo')::date <= (check_in AT TIME ZONE 'UTC' AT TIME ZONE 'America/Sao_Paulo')::date;
+INSERT INTO migration_quarantine (source_table, reason, row_data)
+SELECT 'messages', 'booking does not exist', to_jsonb(m) FROM messages m WHERE m.booking_id IS NOT NULL AND NOT EXISTS (SELECT 1 FROM bookings b WHERE b.id = m.booking_id);
+DELETE FROM messages m WHERE m.booking_id IS NOT NULL AND NOT EXISTS (SELECT 1 FROM bookings b WHERE b.id = m.booking_id);
+
+-- Stay dates are calendar days, not instants. Stored timestamps came from the
+-- frontend as UTC instants of property-local midnight, so they are converted
+-- through the property time zone (America/Sao_Paulo).
+ALTER TABLE bookings
+  ALTER COLUMN check_in TYPE date USING (check_in AT TIME ZONE 'UTC' AT TIME ZONE 'America/Sao_Paulo')::date,
+  ALTER COLUMN check_out TYPE date USING (check_out AT TIME ZONE 'UTC' AT TIME ZONE 'America/Sao_Paulo')::date,
+  ALTER COLUMN check_in SET NOT NULL,
+  ALTER COLUMN check_out SET NOT NULL,
+  ALTER COLUMN status SET DEFAULT 'requested',
+  ALTER COLUMN status SET NOT NULL,
+  ALTER COLUMN guest_name SET NOT NULL,
+  ALTER COLUMN number_of_guests SET DEFAULT 1,
+  ALTER COLUMN number_of_guests SET NOT NULL,
+  ALTER COLUMN created_at SET NOT NULL,
+  ADD CONSTRAINT bookings_status_check CHECK (status IN ('requested', 'approved', 'rejected')),
+  ADD CONSTRAINT bookings_dates_check CHECK (check_out > check_in),
+  ADD CONSTRAINT bookings_guests_check CHECK (number_of_guests > 0),
+  ADD CONSTRAINT bookings_user_email_fkey FOREIGN KEY (user_email) REFERENCES users(email) ON UPDATE CASCADE ON DELETE SET NULL;
+ALTER TABLE messages
+  ADD CONSTRAINT messages_booking_id_fkey FOREIGN KEY (booking_id) REFERENCES bookings(id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS bookings_user_email_idx ON bookings (user_email);
+CREATE INDEX IF NOT EXISTS bookings_status_check_in_idx ON bookings (status, check_in);
+CREATE INDEX IF NOT EXISTS messages_booking_id_created_at_idx ON messages (booking_id, created_at);
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS blocks_dates_idx;
+ALTER TABLE blocks DROP CONSTRAINT IF EXISTS blocks_dates_check;
+ALTER TABLE blocks
+  ALTER COLUMN start_date TYPE TIMESTAMP USING (start_date::timestamp AT TIME ZONE 'America/Sao_Paulo' AT TIME ZONE 'UTC'),
+  ALTER COLUMN end_date TYPE TIMESTAMP USING (end_date::timestamp AT TIME ZONE 'America/Sao_Paulo' AT TIME ZONE 'UTC');
+ALTER TABLE blocks RENAME COLUMN end_date TO to_ts;
+ALTER TABLE blocks RENAME COLUMN start_date TO from_ts;
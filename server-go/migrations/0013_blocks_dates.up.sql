@@ -0,0 +1,11 @@
+-- Blocks become [start_date, end_date) ranges of nights. Stored timestamps
+-- came from the frontend as UTC instants of property-local midnight, so they
+-- are converted through the property time zone (America/Sao_Paulo).
+ALTER TABLE blocks RENAME COLUMN from_ts TO start_date;
+ALTER TABLE blocks RENAME COLUMN to_ts TO end_date;
+ALTER TABLE blocks
+  ALTER COLUMN start_date TYPE date USING (start_date AT TIME ZONE 'UTC' AT TIME ZONE 'America/Sao_Paulo')::date,
+  ALTER COLUMN end_date TYPE date USING (end_date AT TIME ZONE 'UTC' AT TIME ZONE 'America/Sao_Paulo')::date;
+UPDATE blocks SET end_date = start_date + 1 WHERE end_date <= start_date;
+ALTER TABLE blocks ADD CONSTRAINT blocks_dates_check CHECK (end_date > start_date);
+CREATE INDEX IF NOT EXISTS blocks_dates_idx ON blocks (start_date, end_date);
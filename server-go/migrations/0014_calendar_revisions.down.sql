@@ -0,0 +1,7 @@
+DROP TRIGGER IF EXISTS bookings_bump_revision ON bookings;
+DROP FUNCTION IF EXISTS bookings_bump_revision();
+DROP TRIGGER IF EXISTS blocks_bump_revision ON blocks;
+DROP FUNCTION IF EXISTS blocks_bump_revision();
+ALTER TABLE bookings DROP COLUMN IF EXISTS sequence;
+ALTER TABLE blocks DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE blocks DROP COLUMN IF EXISTS sequence;
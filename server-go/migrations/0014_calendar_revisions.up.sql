@@ -0,0 +1,32 @@
+-- Exported events carry SEQUENCE and LAST-MODIFIED so calendar platforms can
+-- tell real changes apart from re-fetches. The triggers bump them only when
+-- something visible in the feed changes.
+ALTER TABLE blocks ADD COLUMN IF NOT EXISTS sequence INT NOT NULL DEFAULT 0;
+ALTER TABLE blocks ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP;
+UPDATE blocks SET updated_at = COALESCE(created_at, now()) WHERE updated_at IS NULL;
+ALTER TABLE blocks ALTER COLUMN updated_at SET DEFAULT now(), ALTER COLUMN updated_at SET NOT NULL;
+ALTER TABLE bookings ADD COLUMN IF NOT EXISTS sequence INT NOT NULL DEFAULT 0;
+
+CREATE OR REPLACE FUNCTION blocks_bump_revision() RETURNS trigger AS $$
+BEGIN
+  IF (NEW.start_date, NEW.end_date, NEW.note) IS DISTINCT FROM (OLD.start_date, OLD.end_date, OLD.note) THEN
+    NEW.sequence := OLD.sequence + 1;
+    NEW.updated_at := now();
+  END IF;
+  RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+DROP TRIGGER IF EXISTS blocks_bump_revision ON blocks;
+CREATE TRIGGER blocks_bump_revision BEFORE UPDATE ON blocks FOR EACH ROW EXECUTE FUNCTION blocks_bump_revision();
+
+CREATE OR REPLACE FUNCTION bookings_bump_revision() RETURNS trigger AS $$
+BEGIN
+  IF (NEW.check_in, NEW.check_out, NEW.status, NEW.guest_name) IS DISTINCT FROM (OLD.check_in, OLD.check_out, OLD.status, OLD.guest_name) THEN
+    NEW.sequence := OLD.sequence + 1;
+    NEW.updated_at := now();
+  END IF;
+  RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+DROP TRIGGER IF EXISTS bookings_bump_revision ON bookings;
+CREATE TRIGGER bookings_bump_revision BEFORE UPDATE ON bookings FOR EACH ROW EXECUTE FUNCTION bookings_bump_revision();
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS users_calendar_token_idx;
+ALTER TABLE users DROP COLUMN IF EXISTS calendar_token;
+DROP INDEX IF EXISTS icals_export_token_idx;
+ALTER TABLE icals DROP COLUMN IF EXISTS export_private;
+ALTER TABLE icals DROP COLUMN IF EXISTS export_token;
@@ -0,0 +1,9 @@
+-- Export feeds are capability URLs: the token is shown to the owner so it can
+-- be pasted into each channel, and rotating it cuts off the old URL.
+ALTER TABLE icals ADD COLUMN IF NOT EXISTS export_token TEXT;
+ALTER TABLE icals ADD COLUMN IF NOT EXISTS export_private BOOLEAN NOT NULL DEFAULT TRUE;
+UPDATE icals SET export_token = encode(gen_random_bytes(24), 'hex') WHERE export_token IS NULL;
+ALTER TABLE icals ALTER COLUMN export_token SET DEFAULT encode(gen_random_bytes(24), 'hex'), ALTER COLUMN export_token SET NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS icals_export_token_idx ON icals (export_token);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS calendar_token TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS users_calendar_token_idx ON users (calendar_token);
@@ -0,0 +1,2 @@
+ALTER TABLE icals DROP COLUMN IF EXISTS last_success_at;
+DROP TABLE IF EXISTS ical_events;
@@ -0,0 +1,16 @@
+-- Events of imported feeds, replaced on every successful background sync so
+-- readers never fetch feeds themselves. last_success_at tells how stale they
+-- are; last_synced_at still records the latest attempt.
+CREATE TABLE IF NOT EXISTS ical_events (
+  feed_id INT NOT NULL REFERENCES icals(id) ON DELETE CASCADE,
+  uid TEXT NOT NULL,
+  summary TEXT NOT NULL DEFAULT '',
+  status TEXT NOT NULL DEFAULT '',
+  start_date DATE NOT NULL,
+  end_date DATE NOT NULL,
+  stamp TIMESTAMPTZ,
+  modified TIMESTAMPTZ,
+  sequence INT NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS ical_events_feed_idx ON ical_events (feed_id);
+ALTER TABLE icals ADD COLUMN IF NOT EXISTS last_success_at TIMESTAMPTZ;
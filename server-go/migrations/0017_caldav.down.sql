@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS app_passwords;
+DROP INDEX IF EXISTS blocks_caldav_name_idx;
+DROP INDEX IF EXISTS blocks_uid_idx;
+ALTER TABLE blocks DROP COLUMN IF EXISTS caldav_name;
+ALTER TABLE blocks DROP COLUMN IF EXISTS uid;
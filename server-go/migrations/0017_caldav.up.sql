@@ -0,0 +1,19 @@
+-- Blocks created from a calendar app keep the client's UID and resource
+-- name, so the app finds its event again under the href it chose.
+ALTER TABLE blocks ADD COLUMN IF NOT EXISTS uid TEXT;
+ALTER TABLE blocks ADD COLUMN IF NOT EXISTS caldav_name TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS blocks_uid_idx ON blocks (uid);
+CREATE UNIQUE INDEX IF NOT EXISTS blocks_caldav_name_idx ON blocks (caldav_name);
+
+-- App passwords let calendar apps, which only speak Basic auth, sign in
+-- without the account password. Only the hash is stored.
+CREATE TABLE IF NOT EXISTS app_passwords (
+  id SERIAL PRIMARY KEY,
+  owner_email TEXT NOT NULL,
+  name TEXT NOT NULL,
+  password_hash TEXT NOT NULL,
+  last_used_at TIMESTAMP,
+  revoked_at TIMESTAMP,
+  created_at TIMESTAMP DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS app_passwords_owner_email_idx ON app_passwords (owner_email);
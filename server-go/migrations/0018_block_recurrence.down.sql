@@ -0,0 +1,11 @@
+CREATE OR REPLACE FUNCTION blocks_bump_revision() RETURNS trigger AS $$
+BEGIN
+  IF (NEW.start_date, NEW.end_date, NEW.note) IS DISTINCT FROM (OLD.start_date, OLD.end_date, OLD.note) THEN
+    NEW.sequence := OLD.sequence + 1;
+    NEW.updated_at := now();
+  END IF;
+  RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+ALTER TABLE blocks DROP COLUMN IF EXISTS exdates;
+ALTER TABLE blocks DROP COLUMN IF EXISTS rrule;
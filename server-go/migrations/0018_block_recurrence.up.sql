@@ -0,0 +1,14 @@
+-- Recurring blocks: an RRULE and excluded dates, expanded when computing
+-- availability. start_date/end_date are the first occurrence.
+ALTER TABLE blocks ADD COLUMN IF NOT EXISTS rrule TEXT;
+ALTER TABLE blocks ADD COLUMN IF NOT EXISTS exdates DATE[] NOT NULL DEFAULT '{}';
+
+CREATE OR REPLACE FUNCTION blocks_bump_revision() RETURNS trigger AS $$
+BEGIN
+  IF (NEW.start_date, NEW.end_date, NEW.note, NEW.rrule, NEW.exdates) IS DISTINCT FROM (OLD.start_date, OLD.end_date, OLD.note, OLD.rrule, OLD.exdates) THEN
+    NEW.sequence := OLD.sequence + 1;
+    NEW.updated_at := now();
+  END IF;
+  RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,443 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "crypto/hmac"
+    "crypto/sha256"
+    "crypto/subtle"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "net/smtp"
+    "os"
+    "strings"
+    "time"
+    "github.com/gorilla/mux"
+    "github.com/jackc/pgx/v5"
+    "github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+    ChannelEmail = "email"
+    ChannelWhatsApp = "whatsapp"
+    ChannelSMS = "sms"
+)
+
+var defaultChannels = []string{ChannelEmail, ChannelWhatsApp}
+
+// Notification is a channel-agnostic event sent to a guest or owner.
+type Notification struct {
+    Event string
+    BookingID string
+    Subject string
+    Text string
+}
+
+type Recipient struct {
+    Email string
+    Phone string
+}
+
+// Notifier delivers a notification over a single channel.
+type Notifier interface {
+    Channel() string
+    Send(ctx context.Context, to Recipient, n Notification) error
+}
+
+// EmailNotifier sends plain-text mail through an SMTP relay.
+type EmailNotifier struct {
+    Addr string
+    From string
+    Auth smtp.Auth
+}
+
+func (e *EmailNotifier) Channel() string { return ChannelEmail }
+
+func (e *EmailNotifier) Send(ctx context.Context, to Recipient, n Notification) error {
+    if to.Email == "" { return errors.New("missing email") }
+    var b strings.Builder
+    b.WriteString("From: " + e.From + "\r\n")
+    b.WriteString("To: " + to.Email + "\r\n")
+    b.WriteString("Subject: " + n.Subject + "\r\n")
+    b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+    b.WriteString(n.Text + "\r\n")
+    return smtp.SendMail(e.Addr, e.Auth, e.From, []string{to.Email}, []byte(b.String()))
+}
+
+// WhatsAppNotifier uses the WhatsApp Business Cloud API. When Template is set
+// the message is sent as a template with the text as its single body parameter,
+// which is required for business-initiated conversations.
+type WhatsAppNotifier struct {
+    APIBase string
+    PhoneNumberID string
+    Token string
+    Template string
+    Language string
+    Client *http.Client
+}
+
+func (wa *WhatsAppNotifier) Channel() string { return ChannelWhatsApp }
+
+func (wa *WhatsAppNotifier) Send(ctx context.Context, to Recipient, n Notification) error {
+    phone := normalizePhone(to.Phone)
+    if phone == "" { return errors.New("missing phone") }
+    msg := map[string]any{"messaging_product": "whatsapp", "to": phone}
+    if wa.Template != "" {
+        msg["type"] = "template"
+        msg["template"] = map[string]any{
+            "name": wa.Template,
+            "language": map[string]string{"code": wa.Language},
+            "components": []any{map[string]any{"type": "body", "parameters": []any{map[string]string{"type": "text", "text": n.Text}}}},
+        }
+    } else {
+        msg["type"] = "text"
+        msg["text"] = map[string]any{"preview_url": false, "body": n.Text}
+    }
+    url := strings.TrimRight(wa.APIBase, "/") + "/" + wa.PhoneNumberID + "/messages"
+    return postJSON(ctx, wa.Client, url, "Bearer "+wa.Token, msg)
+}
+
+// SMSNotifier posts {from, to, text} to a generic HTTP SMS gateway.
+type SMSNotifier struct {
+    URL string
+    Token string
+    From string
+    Client *http.Client
+}
+
+func (sm *SMSNotifier) Channel() string { return ChannelSMS }
+
+func (sm *SMSNotifier) Send(ctx context.Context, to Recipient, n Notification) error {
+    phone := normalizePhone(to.Phone)
+    if phone == "" { return errors.New("missing phone") }
+    auth := ""
+    if sm.Token != "" { auth = "Bearer " + sm.Token }
+    return postJSON(ctx, sm.Client, sm.URL, auth, map[string]string{"from": sm.From, "to": "+" + phone, "text": n.Text})
+}
+
+// LogNotifier only logs that a notification would have been sent, for
+// development without provider credentials.
+type LogNotifier struct{ Name string }
+
+func (l *LogNotifier) Channel() string { return l.Name }
+
+func (l *LogNotifier) Send(ctx context.Context, to Recipient, n Notification) error {
+    log.Printf("notificação %s (%s) reserva %s", n.Event, l.Name, n.BookingID)
+    return nil
+}
+
+func postJSON(ctx context.Context, client *http.Client, url, auth string, v any) error {
+    payload, err := json.Marshal(v)
+    if err != nil { return err }
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+    if err != nil { return err }
+    req.Header.Set("Content-Type", "application/json")
+    if auth != "" { req.Header.Set("Authorization", auth) }
+    resp, err := client.Do(req)
+    if err != nil { return err }
+    defer resp.Body.Close()
+    if resp.StatusCode >= 300 {
+        body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+        return fmt.Errorf("%s: %d %s", url, resp.StatusCode, strings.TrimSpace(string(body)))
+    }
+    return nil
+}
+
+// normalizePhone keeps only digits and assumes Brazil (+55) for national
+// numbers; a leading + marks the number as already international.
+func normalizePhone(p string) string {
+    var b strings.Builder
+    for _, r := range p { if r >= '0' && r <= '9' { b.WriteRune(r) } }
+    d := strings.TrimLeft(b.String(), "0")
+    intl := strings.HasPrefix(strings.TrimSpace(p), "+")
+    if !intl && (len(d) == 10 || len(d) == 11) && !strings.HasPrefix(d, "55") { d = "55" + d }
+    return d
+}
+
+// NotificationPrefs is a user's saved phone number and channel choice.
+type NotificationPrefs struct {
+    Phone string
+    Channels []string
+    OptedOut bool
+}
+
+// NotificationStore keeps channel preferences and the opt-outs phones sent
+// by replying STOP.
+type NotificationStore interface {
+    // Prefs returns the user's preferences; ok is false when none are saved.
+    Prefs(ctx context.Context, email string) (NotificationPrefs, bool, error)
+    OptedOut(ctx context.Context, phone, channel string) (bool, error)
+    SetOptOut(ctx context.Context, phone, channel string, out bool) error
+}
+
+// PostgresNotificationStore reads notification_prefs and notification_optouts.
+type PostgresNotificationStore struct {
+    pool *pgxpool.Pool
+}
+
+func (p *PostgresNotificationStore) Prefs(ctx context.Context, email string) (NotificationPrefs, bool, error) {
+    var np NotificationPrefs
+    err := p.pool.QueryRow(ctx, "SELECT COALESCE(phone,''), channels, opted_out FROM notification_prefs WHERE user_email=$1", email).Scan(&np.Phone, &np.Channels, &np.OptedOut)
+    if errors.Is(err, pgx.ErrNoRows) { return np, false, nil }
+    return np, err == nil, err
+}
+
+func (p *PostgresNotificationStore) OptedOut(ctx context.Context, phone, channel string) (bool, error) {
+    var out bool
+    err := p.pool.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM notification_optouts WHERE phone=$1 AND channel=$2)", phone, channel).Scan(&out)
+    return out, err
+}
+
+func (p *PostgresNotificationStore) SetOptOut(ctx context.Context, phone, channel string, out bool) error {
+    q := "DELETE FROM notification_optouts WHERE phone=$1 AND channel=$2"
+    if out { q = "INSERT INTO notification_optouts (phone, channel) VALUES ($1,$2) ON CONFLICT DO NOTHING" }
+    _, err := p.pool.Exec(ctx, q, phone, channel)
+    return err
+}
+
+const (
+    notifyAttempts = 3
+    notifyBackoff = 2 * time.Second
+)
+
+// NotificationDispatcher fans a notification out to the channels a recipient
+// accepts, skipping opted-out phone numbers. Failed sends are retried with
+// exponential backoff.
+type NotificationDispatcher struct {
+    store NotificationStore
+    providers map[string]Notifier
+    attempts int
+    backoff time.Duration
+}
+
+func NewNotificationDispatcher(store NotificationStore, providers ...Notifier) *NotificationDispatcher {
+    d := &NotificationDispatcher{ store: store, providers: make(map[string]Notifier), attempts: notifyAttempts, backoff: notifyBackoff }
+    for _, p := range providers { d.providers[p.Channel()] = p }
+    return d
+}
+
+func notifiersFromEnv() []Notifier {
+    if os.Getenv("NOTIFY_LOG") == "1" {
+        return []Notifier{&LogNotifier{Name: ChannelEmail}, &LogNotifier{Name: ChannelWhatsApp}, &LogNotifier{Name: ChannelSMS}}
+    }
+    client := &http.Client{ Timeout: 10 * time.Second }
+    var out []Notifier
+    if host := os.Getenv("SMTP_HOST"); host != "" {
+        port := os.Getenv("SMTP_PORT")
+        if port == "" { port = "587" }
+        var auth smtp.Auth
+        if u := os.Getenv("SMTP_USER"); u != "" { auth = smtp.PlainAuth("", u, os.Getenv("SMTP_PASS"), host) }
+        out = append(out, &EmailNotifier{ Addr: host + ":" + port, From: os.Getenv("SMTP_FROM"), Auth: auth })
+    }
+    if token := os.Getenv("WHATSAPP_TOKEN"); token != "" {
+        base := os.Getenv("WHATSAPP_API_BASE")
+        if base == "" { base = "https://graph.facebook.com/v19.0" }
+        lang := os.Getenv("WHATSAPP_TEMPLATE_LANG")
+        if lang == "" { lang = "pt_BR" }
+        out = append(out, &WhatsAppNotifier{ APIBase: base, PhoneNumberID: os.Getenv("WHATSAPP_PHONE_NUMBER_ID"), Token: token, Template: os.Getenv("WHATSAPP_TEMPLATE"), Language: lang, Client: client })
+    }
+    if u := os.Getenv("SMS_API_URL"); u != "" {
+        out = append(out, &SMSNotifier{ URL: u, Token: os.Getenv("SMS_API_TOKEN"), From: os.Getenv("SMS_FROM"), Client: client })
+    }
+    return out
+}
+
+// Notify delivers n in the background so handlers never wait on providers.
+func (d *NotificationDispatcher) Notify(to Recipient, n Notification) {
+    if d == nil || len(d.providers) == 0 { return }
+    go func() {
+        ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+        defer cancel()
+        d.deliver(ctx, to, n)
+    }()
+}
+
+func (d *NotificationDispatcher) deliver(ctx context.Context, to Recipient, n Notification) {
+    for _, ch := range d.channelsFor(ctx, &to) {
+        p, ok := d.providers[ch]
+        if !ok { continue }
+        if ch != ChannelEmail && (to.Phone == "" || d.optedOut(ctx, to.Phone, ch)) { continue }
+        if ch == ChannelEmail && to.Email == "" { continue }
+        if err := d.send(ctx, p, to, n); err != nil { log.Printf("notify %s %s: %v", ch, n.Event, err) }
+    }
+}
+
+// send tries a provider up to d.attempts times, doubling the wait each time.
+func (d *NotificationDispatcher) send(ctx context.Context, p Notifier, to Recipient, n Notification) error {
+    var err error
+    for i := 0; i < d.attempts; i++ {
+        if i > 0 {
+            select {
+            case <-ctx.Done(): return err
+            case <-time.After(d.backoff << (i - 1)):
+            }
+        }
+        if err = p.Send(ctx, to, n); err == nil { return nil }
+    }
+    return err
+}
+
+// SendEmail delivers a transactional email regardless of channel preferences.
+func (d *NotificationDispatcher) SendEmail(email string, n Notification) {
+    p, ok := d.providers[ChannelEmail]
+    if !ok { log.Printf("notify %s: no email provider configured", n.Event); return }
+    go func() {
+        ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+        defer cancel()
+        if err := d.send(ctx, p, Recipient{ Email: email }, n); err != nil { log.Printf("notify email %s: %v", n.Event, err) }
+    }()
+}
+
+// channelsFor returns the recipient's preferred channels, filling in the phone
+// number from their preferences when the event didn't carry one.
+func (d *NotificationDispatcher) channelsFor(ctx context.Context, to *Recipient) []string {
+    if to.Email == "" { return defaultChannels }
+    np, ok, err := d.store.Prefs(ctx, to.Email)
+    if err != nil { log.Println("notification prefs:", err) }
+    if !ok { return defaultChannels }
+    if np.OptedOut { return nil }
+    if to.Phone == "" { to.Phone = np.Phone }
+    return np.Channels
+}
+
+// optedOut fails closed: a phone whose opt-out can't be checked isn't messaged.
+func (d *NotificationDispatcher) optedOut(ctx context.Context, phone, channel string) bool {
+    out, err := d.store.OptedOut(ctx, normalizePhone(phone), channel)
+    if err != nil { log.Println("notification opt-out:", err); return true }
+    return out
+}
+
+var optOutKeywords = map[string]bool{"STOP": true, "PARAR": true, "SAIR": true, "CANCELAR": true}
+var optInKeywords = map[string]bool{"START": true, "VOLTAR": true}
+
+// applyReply records a STOP or START keyword replied from phone on channel.
+// Opt-outs are only ever lifted this way, by the phone itself.
+func (d *NotificationDispatcher) applyReply(ctx context.Context, channel, from, text string) error {
+    phone := normalizePhone(from)
+    kw := strings.ToUpper(strings.TrimSpace(text))
+    if phone == "" { return nil }
+    if optOutKeywords[kw] { return d.store.SetOptOut(ctx, phone, channel, true) }
+    if optInKeywords[kw] { return d.store.SetOptOut(ctx, phone, channel, false) }
+    return nil
+}
+
+func bookingNotification(event, bookingID, guestName string, ci, co time.Time) Notification {
+    dates := ci.Format("02/01/2006") + " a " + co.Format("02/01/2006")
+    n := Notification{ Event: event, BookingID: bookingID }
+    switch event {
+    case "booking.requested":
+        n.Subject = "Recebemos sua solicitação de reserva"
+        n.Text = "Olá " + guestName + ", recebemos sua solicitação de reserva para " + dates + ". Avisaremos assim que for confirmada."
+    case "booking.approved":
+        n.Subject = "Sua reserva foi confirmada"
+        n.Text = "Olá " + guestName + ", sua reserva para " + dates + " foi confirmada. Até breve!"
+    case "booking.rejected":
+        n.Subject = "Sua reserva não pôde ser confirmada"
+        n.Text = "Olá " + guestName + ", infelizmente não foi possível confirmar sua reserva para " + dates + "."
+    }
+    return n
+}
+
+// notifyBooking sends a booking lifecycle event to the booking's guest.
+func (s *Server) notifyBooking(ctx context.Context, bookingID, event string) {
+    var guestName, guestEmail, guestPhone string; var ci, co time.Time
+    err := s.pool.QueryRow(ctx, "SELECT COALESCE(guest_name,''), COALESCE(guest_email,''), COALESCE(guest_phone,''), check_in, check_out FROM bookings WHERE id=$1", bookingID).Scan(&guestName, &guestEmail, &guestPhone, &ci, &co)
+    if err != nil { log.Println("notify booking:", err); return }
+    s.notifier.Notify(Recipient{ Email: guestEmail, Phone: guestPhone }, bookingNotification(event, bookingID, guestName, ci, co))
+}
+
+// notifyMessage tells the other side of a booking conversation about a new message.
+func (s *Server) notifyMessage(ctx context.Context, bookingID, sender string, fromOwner bool, text string) {
+    n := Notification{ Event: "message.created", BookingID: bookingID, Subject: "Nova mensagem sobre sua reserva", Text: "Nova mensagem de " + sender + ": " + text }
+    if fromOwner {
+        var guestEmail, guestPhone string
+        if err := s.pool.QueryRow(ctx, "SELECT COALESCE(guest_email,''), COALESCE(guest_phone,'') FROM bookings WHERE id=$1", bookingID).Scan(&guestEmail, &guestPhone); err != nil { log.Println("notify message:", err); return }
+        s.notifier.Notify(Recipient{ Email: guestEmail, Phone: guestPhone }, n)
+        return
+    }
+    rows, err := s.pool.Query(ctx, "SELECT email FROM users WHERE is_owner")
+    if err != nil { log.Println("notify message:", err); return }
+    defer rows.Close()
+    for rows.Next() {
+        var email string
+        if err := rows.Scan(&email); err != nil { log.Println("notify message:", err); return }
+        s.notifier.Notify(Recipient{ Email: email }, n)
+    }
+}
+
+func (s *Server) handleGetNotificationPrefs(w http.ResponseWriter, r *http.Request) {
+    c := getClaims(r)
+    phone, channels, optedOut := "", defaultChannels, false
+    err := s.pool.QueryRow(r.Context(), "SELECT COALESCE(phone,''), channels, opted_out FROM notification_prefs WHERE user_email=$1", c["email"]).Scan(&phone, &channels, &optedOut)
+    if err != nil && !errors.Is(err, pgx.ErrNoRows) { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    jsonResp(w, 200, map[string]any{"phone": phone, "channels": channels, "opted_out": optedOut})
+}
+
+func (s *Server) handlePutNotificationPrefs(w http.ResponseWriter, r *http.Request) {
+    c := getClaims(r)
+    var body struct{ Phone string; Channels []string; OptedOut bool }
+    _ = json.NewDecoder(r.Body).Decode(&body)
+    for _, ch := range body.Channels {
+        if ch != ChannelEmail && ch != ChannelWhatsApp && ch != ChannelSMS { jsonResp(w, 400, map[string]string{"error":"invalid_channel"}); return }
+    }
+    if body.Channels == nil { body.Channels = []string{} }
+    // A STOP sent from the phone stays in force whatever is saved here; only
+    // a START from that phone lifts it.
+    if _, err := s.pool.Exec(r.Context(), "INSERT INTO notification_prefs (user_email, phone, channels, opted_out, updated_at) VALUES ($1,$2,$3,$4,now()) ON CONFLICT (user_email) DO UPDATE SET phone=EXCLUDED.phone, channels=EXCLUDED.channels, opted_out=EXCLUDED.opted_out, updated_at=now()", c["email"], normalizePhone(body.Phone), body.Channels, body.OptedOut); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    s.audit(r, auditEntry{ Action: "notification_prefs.update", TargetType: "user", TargetID: fmt.Sprint(c["email"]), After: map[string]any{"channels": body.Channels, "opted_out": body.OptedOut} })
+    jsonResp(w, 200, map[string]bool{"success": true})
+}
+
+// validSignature checks a "sha256=<hex>" HMAC-SHA256 of the raw body, the
+// format Meta sends in X-Hub-Signature-256.
+func validSignature(secret string, body []byte, header string) bool {
+    hexSum, ok := strings.CutPrefix(header, "sha256=")
+    if secret == "" || !ok { return false }
+    got, err := hex.DecodeString(hexSum)
+    if err != nil { return false }
+    mac := hmac.New(sha256.New, []byte(secret))
+    mac.Write(body)
+    return hmac.Equal(got, mac.Sum(nil))
+}
+
+// handleNotificationInbound receives replies from the WhatsApp Cloud API
+// webhook or a generic SMS gateway ({from, text}) and applies STOP/START.
+// WhatsApp requests are signed with the app secret; the SMS gateway must
+// sign the same way with SMS_INBOUND_SECRET in X-Signature-256.
+func (s *Server) handleNotificationInbound(w http.ResponseWriter, r *http.Request) {
+    channel := mux.Vars(r)["channel"]
+    if channel != ChannelWhatsApp && channel != ChannelSMS { jsonResp(w, 404, map[string]string{"error":"not_found"}); return }
+    if r.Method == http.MethodGet {
+        // WhatsApp webhook verification handshake
+        q := r.URL.Query()
+        token := os.Getenv("NOTIFY_INBOUND_TOKEN")
+        if channel != ChannelWhatsApp || token == "" || q.Get("hub.mode") != "subscribe" || subtle.ConstantTimeCompare([]byte(q.Get("hub.verify_token")), []byte(token)) != 1 { jsonResp(w, 403, map[string]string{"error":"forbidden"}); return }
+        _, _ = w.Write([]byte(q.Get("hub.challenge")))
+        return
+    }
+    raw, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+    if err != nil { jsonResp(w, 400, map[string]string{"error":"invalid_body"}); return }
+    secret, header := os.Getenv("WHATSAPP_APP_SECRET"), r.Header.Get("X-Hub-Signature-256")
+    if channel == ChannelSMS { secret, header = os.Getenv("SMS_INBOUND_SECRET"), r.Header.Get("X-Signature-256") }
+    if !validSignature(secret, raw, header) { jsonResp(w, 403, map[string]string{"error":"forbidden"}); return }
+    type inbound struct{ From, Text string }
+    var msgs []inbound
+    if channel == ChannelWhatsApp {
+        var body struct{ Entry []struct{ Changes []struct{ Value struct{ Messages []struct{ From string `json:"from"`; Text struct{ Body string `json:"body"` } `json:"text"` } `json:"messages"` } `json:"value"` } `json:"changes"` } `json:"entry"` }
+        _ = json.Unmarshal(raw, &body)
+        for _, e := range body.Entry { for _, c := range e.Changes { for _, m := range c.Value.Messages { msgs = append(msgs, inbound{m.From, m.Text.Body}) } } }
+    } else {
+        var body inbound
+        _ = json.Unmarshal(raw, &body)
+        msgs = append(msgs, body)
+    }
+    for _, m := range msgs {
+        if err := s.notifier.applyReply(r.Context(), channel, m.From, m.Text); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    }
+    jsonResp(w, 200, map[string]bool{"success": true})
+}
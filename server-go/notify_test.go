@@ -0,0 +1,205 @@
+package main
+
+import (
+    "context"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+type FakeMessage struct {
+    To Recipient
+    Notification Notification
+}
+
+// FakeNotifier records notifications in memory instead of sending them.
+// Sends fail with Err when it is set; with FailTimes > 0 only that many
+// attempts fail and the following ones succeed.
+type FakeNotifier struct {
+    Name string
+    Err error
+    FailTimes int
+    mu sync.Mutex
+    attempts int
+    sent []FakeMessage
+}
+
+func (f *FakeNotifier) Channel() string { return f.Name }
+
+func (f *FakeNotifier) Send(ctx context.Context, to Recipient, n Notification) error {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    f.attempts++
+    if f.Err != nil && (f.FailTimes == 0 || f.attempts <= f.FailTimes) { return f.Err }
+    f.sent = append(f.sent, FakeMessage{To: to, Notification: n})
+    return nil
+}
+
+func (f *FakeNotifier) Attempts() int {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    return f.attempts
+}
+
+func (f *FakeNotifier) Sent() []FakeMessage {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    return append([]FakeMessage(nil), f.sent...)
+}
+
+// memNotificationStore is an in-memory NotificationStore for tests.
+type memNotificationStore struct {
+    prefs map[string]NotificationPrefs
+    optouts map[string]bool
+}
+
+func newMemNotificationStore() *memNotificationStore {
+    return &memNotificationStore{ prefs: map[string]NotificationPrefs{}, optouts: map[string]bool{} }
+}
+
+func (m *memNotificationStore) Prefs(ctx context.Context, email string) (NotificationPrefs, bool, error) {
+    np, ok := m.prefs[email]
+    return np, ok, nil
+}
+
+func (m *memNotificationStore) OptedOut(ctx context.Context, phone, channel string) (bool, error) {
+    return m.optouts[phone+"|"+channel], nil
+}
+
+func (m *memNotificationStore) SetOptOut(ctx context.Context, phone, channel string, out bool) error {
+    if out { m.optouts[phone+"|"+channel] = true } else { delete(m.optouts, phone+"|"+channel) }
+    return nil
+}
+
+func newTestDispatcher(store NotificationStore, providers ...Notifier) *NotificationDispatcher {
+    d := NewNotificationDispatcher(store, providers...)
+    d.backoff = 0
+    return d
+}
+
+func TestNormalizePhone(t *testing.T) {
+    tests := []struct{ in, want string }{
+        {"(11) 98888-7777", "5511988887777"},
+        {"11 3333-4444", "551133334444"},
+        {"+55 11 98888-7777", "5511988887777"},
+        {"011 98888-7777", "5511988887777"},
+        {"+1 415 555 0100", "14155550100"},
+        {"", ""},
+        {"abc", ""},
+    }
+    for _, tt := range tests {
+        if got := normalizePhone(tt.in); got != tt.want { t.Errorf("normalizePhone(%q) = %q, want %q", tt.in, got, tt.want) }
+    }
+}
+
+func TestDeliverUsesPreferences(t *testing.T) {
+    tests := []struct {
+        name string
+        prefs *NotificationPrefs
+        to Recipient
+        wantEmail, wantWhatsApp, wantSMS int
+    }{
+        {"defaults", nil, Recipient{ Email: "g@example.com", Phone: "11988887777" }, 1, 1, 0},
+        {"no phone", nil, Recipient{ Email: "g@example.com" }, 1, 0, 0},
+        {"sms only", &NotificationPrefs{ Channels: []string{ChannelSMS} }, Recipient{ Email: "g@example.com", Phone: "11988887777" }, 0, 0, 1},
+        {"phone from prefs", &NotificationPrefs{ Phone: "11988887777", Channels: []string{ChannelWhatsApp} }, Recipient{ Email: "g@example.com" }, 0, 1, 0},
+        {"opted out", &NotificationPrefs{ Channels: []string{ChannelEmail, ChannelWhatsApp}, OptedOut: true }, Recipient{ Email: "g@example.com", Phone: "11988887777" }, 0, 0, 0},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            store := newMemNotificationStore()
+            if tt.prefs != nil { store.prefs["g@example.com"] = *tt.prefs }
+            email, wa, sms := &FakeNotifier{ Name: ChannelEmail }, &FakeNotifier{ Name: ChannelWhatsApp }, &FakeNotifier{ Name: ChannelSMS }
+            newTestDispatcher(store, email, wa, sms).deliver(context.Background(), tt.to, Notification{ Event: "booking.approved", Text: "ok" })
+            if len(email.Sent()) != tt.wantEmail || len(wa.Sent()) != tt.wantWhatsApp || len(sms.Sent()) != tt.wantSMS {
+                t.Errorf("sent email=%d whatsapp=%d sms=%d, want %d/%d/%d", len(email.Sent()), len(wa.Sent()), len(sms.Sent()), tt.wantEmail, tt.wantWhatsApp, tt.wantSMS)
+            }
+        })
+    }
+}
+
+func TestDeliverRetries(t *testing.T) {
+    tests := []struct {
+        name string
+        failTimes int
+        wantAttempts, wantSent int
+    }{
+        {"first try", 0, 1, 1},
+        {"recovers", 2, 3, 1},
+        {"gives up", 5, notifyAttempts, 0},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            wa := &FakeNotifier{ Name: ChannelWhatsApp, FailTimes: tt.failTimes }
+            if tt.failTimes > 0 { wa.Err = errors.New("provider down") }
+            store := newMemNotificationStore()
+            store.prefs["g@example.com"] = NotificationPrefs{ Channels: []string{ChannelWhatsApp} }
+            newTestDispatcher(store, wa).deliver(context.Background(), Recipient{ Email: "g@example.com", Phone: "11988887777" }, Notification{ Event: "booking.requested" })
+            if wa.Attempts() != tt.wantAttempts || len(wa.Sent()) != tt.wantSent {
+                t.Errorf("attempts=%d sent=%d, want %d/%d", wa.Attempts(), len(wa.Sent()), tt.wantAttempts, tt.wantSent)
+            }
+        })
+    }
+}
+
+func TestStopAndStartReplies(t *testing.T) {
+    store := newMemNotificationStore()
+    email, wa := &FakeNotifier{ Name: ChannelEmail }, &FakeNotifier{ Name: ChannelWhatsApp }
+    d := newTestDispatcher(store, email, wa)
+    to := Recipient{ Email: "g@example.com", Phone: "(11) 98888-7777" }
+    ctx := context.Background()
+    steps := []struct {
+        channel, from, text string
+        wantWhatsApp int
+    }{
+        {ChannelWhatsApp, "5511988887777", " stop ", 0},
+        {ChannelSMS, "5511988887777", "START", 0},
+        {ChannelWhatsApp, "5511900000000", "START", 0},
+        {ChannelWhatsApp, "5511988887777", "olá", 0},
+        {ChannelWhatsApp, "+55 (11) 98888-7777", "Voltar", 1},
+        {ChannelWhatsApp, "5511988887777", "PARAR", 1},
+    }
+    for i, st := range steps {
+        if err := d.applyReply(ctx, st.channel, st.from, st.text); err != nil { t.Fatal(err) }
+        d.deliver(ctx, to, Notification{ Event: "message.created" })
+        if got := len(wa.Sent()); got != st.wantWhatsApp { t.Errorf("step %d (%s %q): whatsapp sent %d, want %d", i, st.channel, st.text, got, st.wantWhatsApp) }
+        if got := len(email.Sent()); got != i+1 { t.Errorf("step %d: email sent %d, want %d", i, got, i+1) }
+    }
+}
+
+func TestValidSignature(t *testing.T) {
+    body := []byte(`{"entry":[]}`)
+    sign := func(secret string, b []byte) string {
+        mac := hmac.New(sha256.New, []byte(secret))
+        mac.Write(b)
+        return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+    }
+    tests := []struct{ name, secret string; body []byte; header string; want bool }{
+        {"valid", "app-secret", body, sign("app-secret", body), true},
+        {"other secret", "app-secret", body, sign("other", body), false},
+        {"tampered body", "app-secret", []byte(`{"entry":[{}]}`), sign("app-secret", body), false},
+        {"no prefix", "app-secret", body, strings.TrimPrefix(sign("app-secret", body), "sha256="), false},
+        {"not hex", "app-secret", body, "sha256=zz", false},
+        {"missing header", "app-secret", body, "", false},
+        {"no secret configured", "", body, sign("", body), false},
+    }
+    for _, tt := range tests {
+        if got := validSignature(tt.secret, tt.body, tt.header); got != tt.want { t.Errorf("%s: validSignature = %v, want %v", tt.name, got, tt.want) }
+    }
+}
+
+func TestSendEmailIgnoresPreferences(t *testing.T) {
+    store := newMemNotificationStore()
+    store.prefs["g@example.com"] = NotificationPrefs{ Channels: []string{ChannelWhatsApp}, OptedOut: true }
+    email := &FakeNotifier{ Name: ChannelEmail }
+    newTestDispatcher(store, email).SendEmail("g@example.com", Notification{ Event: "account.password_reset", Text: "link" })
+    deadline := time.Now().Add(2 * time.Second)
+    for len(email.Sent()) == 0 && time.Now().Before(deadline) { time.Sleep(5 * time.Millisecond) }
+    sent := email.Sent()
+    if len(sent) != 1 || sent[0].To.Email != "g@example.com" || sent[0].Notification.Event != "account.password_reset" { t.Fatalf("sent = %+v", sent) }
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+    "context"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rsa"
+    "crypto/sha256"
+    "crypto/subtle"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "math/big"
+    "net/http"
+    "net/url"
+    "os"
+    "strings"
+    "sync"
+    "time"
+    "github.com/golang-jwt/jwt/v5"
+    "github.com/jackc/pgx/v5"
+)
+
+const (
+    oidcStateTTL = 10 * time.Minute
+    oidcStateCookie = "oidc_state"
+)
+
+// OIDCProvider is a generic OpenID Connect relying party (Google by default)
+// configured from the issuer's discovery document.
+type OIDCProvider struct {
+    Name string
+    Issuer string
+    ClientID string
+    ClientSecret string
+    RedirectURL string
+    Scopes []string
+    client *http.Client
+
+    mu sync.Mutex
+    discovery *oidcDiscovery
+    discoveredAt time.Time
+    keys map[string]any
+}
+
+type oidcDiscovery struct {
+    Issuer string `json:"issuer"`
+    AuthorizationEndpoint string `json:"authorization_endpoint"`
+    TokenEndpoint string `json:"token_endpoint"`
+    JwksURI string `json:"jwks_uri"`
+}
+
+func oidcProviderFromEnv() *OIDCProvider {
+    clientID := os.Getenv("OIDC_CLIENT_ID")
+    if clientID == "" { return nil }
+    issuer := os.Getenv("OIDC_ISSUER")
+    if issuer == "" { issuer = "https://accounts.google.com" }
+    name := os.Getenv("OIDC_PROVIDER_NAME")
+    if name == "" { name = "google" }
+    scopes := os.Getenv("OIDC_SCOPES")
+    if scopes == "" { scopes = "openid email profile" }
+    return &OIDCProvider{ Name: name, Issuer: strings.TrimRight(issuer, "/"), ClientID: clientID, ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"), RedirectURL: os.Getenv("OIDC_REDIRECT_URL"), Scopes: strings.Fields(scopes), client: &http.Client{ Timeout: 10 * time.Second } }
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, u string, v any) error {
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+    if err != nil { return err }
+    resp, err := p.client.Do(req)
+    if err != nil { return err }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK { return fmt.Errorf("%s: %s", u, resp.Status) }
+    return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (p *OIDCProvider) config(ctx context.Context) (*oidcDiscovery, error) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    if p.discovery != nil && time.Since(p.discoveredAt) < time.Hour { return p.discovery, nil }
+    var d oidcDiscovery
+    if err := p.getJSON(ctx, p.Issuer+"/.well-known/openid-configuration", &d); err != nil { return nil, err }
+    if strings.TrimRight(d.Issuer, "/") != p.Issuer { return nil, errors.New("oidc issuer mismatch") }
+    p.discovery, p.discoveredAt, p.keys = &d, time.Now(), nil
+    return &d, nil
+}
+
+// key returns the JWKS key for kid, refetching the set once if it's unknown
+// so provider key rotation is picked up.
+func (p *OIDCProvider) key(ctx context.Context, kid string) (any, error) {
+    d, err := p.config(ctx)
+    if err != nil { return nil, err }
+    p.mu.Lock()
+    k, ok := p.keys[kid]
+    p.mu.Unlock()
+    if ok { return k, nil }
+    var set struct{ Keys []struct{ Kid, Kty, Crv, N, E, X, Y string } `json:"keys"` }
+    if err := p.getJSON(ctx, d.JwksURI, &set); err != nil { return nil, err }
+    keys := make(map[string]any)
+    for _, jk := range set.Keys {
+        switch jk.Kty {
+        case "RSA":
+            n, err1 := base64.RawURLEncoding.DecodeString(jk.N)
+            e, err2 := base64.RawURLEncoding.DecodeString(jk.E)
+            if err1 != nil || err2 != nil { continue }
+            keys[jk.Kid] = &rsa.PublicKey{ N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64()) }
+        case "EC":
+            if jk.Crv != "P-256" { continue }
+            x, err1 := base64.RawURLEncoding.DecodeString(jk.X)
+            y, err2 := base64.RawURLEncoding.DecodeString(jk.Y)
+            if err1 != nil || err2 != nil { continue }
+            keys[jk.Kid] = &ecdsa.PublicKey{ Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y) }
+        }
+    }
+    p.mu.Lock()
+    p.keys = keys
+    p.mu.Unlock()
+    if k, ok := keys[kid]; ok { return k, nil }
+    return nil, errors.New("oidc: unknown signing key")
+}
+
+func (p *OIDCProvider) authURL(d *oidcDiscovery, state, nonce, verifier string) string {
+    sum := sha256.Sum256([]byte(verifier))
+    v := url.Values{}
+    v.Set("response_type", "code")
+    v.Set("client_id", p.ClientID)
+    v.Set("redirect_uri", p.RedirectURL)
+    v.Set("scope", strings.Join(p.Scopes, " "))
+    v.Set("state", state)
+    v.Set("nonce", nonce)
+    v.Set("code_challenge", base64.RawURLEncoding.EncodeToString(sum[:]))
+    v.Set("code_challenge_method", "S256")
+    sep := "?"
+    if strings.Contains(d.AuthorizationEndpoint, "?") { sep = "&" }
+    return d.AuthorizationEndpoint + sep + v.Encode()
+}
+
+type oidcIdentity struct {
+    Subject string
+    Email string
+    EmailVerified bool
+    Name string
+}
+
+// exchange trades an authorization code for a verified ID token.
+func (p *OIDCProvider) exchange(ctx context.Context, code, verifier, nonce string) (*oidcIdentity, error) {
+    d, err := p.config(ctx)
+    if err != nil { return nil, err }
+    form := url.Values{}
+    form.Set("grant_type", "authorization_code")
+    form.Set("code", code)
+    form.Set("redirect_uri", p.RedirectURL)
+    form.Set("client_id", p.ClientID)
+    form.Set("client_secret", p.ClientSecret)
+    form.Set("code_verifier", verifier)
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+    if err != nil { return nil, err }
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    req.Header.Set("Accept", "application/json")
+    resp, err := p.client.Do(req)
+    if err != nil { return nil, err }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK { return nil, fmt.Errorf("oidc token endpoint: %s", resp.Status) }
+    var tok struct{ IDToken string `json:"id_token"` }
+    if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil { return nil, err }
+    if tok.IDToken == "" { return nil, errors.New("oidc: missing id_token") }
+    parsed, err := jwt.Parse(tok.IDToken, func(t *jwt.Token) (any, error) {
+        kid, _ := t.Header["kid"].(string)
+        return p.key(ctx, kid)
+    }, jwt.WithValidMethods([]string{"RS256", "ES256"}), jwt.WithIssuer(d.Issuer), jwt.WithAudience(p.ClientID), jwt.WithExpirationRequired(), jwt.WithLeeway(time.Minute))
+    if err != nil { return nil, err }
+    claims, ok := parsed.Claims.(jwt.MapClaims)
+    if !ok { return nil, errors.New("oidc: invalid claims") }
+    if n, _ := claims["nonce"].(string); n != nonce { return nil, errors.New("oidc: nonce mismatch") }
+    id := &oidcIdentity{}
+    id.Subject, _ = claims["sub"].(string)
+    id.Email, _ = claims["email"].(string)
+    id.Name, _ = claims["name"].(string)
+    // Some providers send email_verified as a string.
+    switch v := claims["email_verified"].(type) {
+    case bool: id.EmailVerified = v
+    case string: id.EmailVerified = v == "true"
+    }
+    if id.Subject == "" { return nil, errors.New("oidc: missing sub") }
+    return id, nil
+}
+
+// oidcRedirect sends the browser back to the frontend. Tokens go in the
+// fragment so they never reach server logs.
+func oidcRedirect(w http.ResponseWriter, r *http.Request, values url.Values) {
+    http.Redirect(w, r, appURL()+"/auth#"+values.Encode(), http.StatusFound)
+}
+
+// setOIDCStateCookie binds a login attempt to the browser that started it, so
+// a callback URL from someone else's attempt (login CSRF) is refused.
+func setOIDCStateCookie(w http.ResponseWriter, r *http.Request, state string, maxAge int) {
+    http.SetCookie(w, &http.Cookie{ Name: oidcStateCookie, Value: state, Path: "/", MaxAge: maxAge, HttpOnly: true, Secure: r.TLS != nil || strings.HasPrefix(appURL(), "https://"), SameSite: http.SameSiteLaxMode })
+}
+
+// oidcStateMatches reports whether the state returned by the provider is the
+// one stored in this browser's cookie.
+func oidcStateMatches(r *http.Request, state string) bool {
+    c, err := r.Cookie(oidcStateCookie)
+    if err != nil || state == "" { return false }
+    return subtle.ConstantTimeCompare([]byte(c.Value), []byte(hashToken(state))) == 1
+}
+
+func (s *Server) handleOIDCStart(w http.ResponseWriter, r *http.Request) {
+    if s.oidc == nil { jsonResp(w, 404, map[string]string{"error":"oidc_not_configured"}); return }
+    d, err := s.oidc.config(r.Context())
+    if err != nil { jsonResp(w, 502, map[string]string{"error": err.Error()}); return }
+    state, nonce, verifier := randomToken(24), randomToken(24), randomToken(48)
+    if _, err := s.pool.Exec(r.Context(), "INSERT INTO oidc_states (state, nonce, code_verifier, expires_at) VALUES ($1,$2,$3,$4)", hashToken(state), nonce, verifier, time.Now().Add(oidcStateTTL)); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    _, _ = s.pool.Exec(r.Context(), "DELETE FROM oidc_states WHERE expires_at < now()")
+    setOIDCStateCookie(w, r, hashToken(state), int(oidcStateTTL/time.Second))
+    http.Redirect(w, r, s.oidc.authURL(d, state, nonce, verifier), http.StatusFound)
+}
+
+func (s *Server) handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+    if s.oidc == nil { jsonResp(w, 404, map[string]string{"error":"oidc_not_configured"}); return }
+    q := r.URL.Query()
+    matched := oidcStateMatches(r, q.Get("state"))
+    setOIDCStateCookie(w, r, "", -1)
+    if e := q.Get("error"); e != "" { oidcRedirect(w, r, url.Values{"error": {e}}); return }
+    if !matched { oidcRedirect(w, r, url.Values{"error": {"invalid_state"}}); return }
+    var nonce, verifier string
+    err := s.pool.QueryRow(r.Context(), "DELETE FROM oidc_states WHERE state=$1 AND expires_at > now() RETURNING nonce, code_verifier", hashToken(q.Get("state"))).Scan(&nonce, &verifier)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) { oidcRedirect(w, r, url.Values{"error": {"invalid_state"}}); return }
+        log.Println("oidc state:", err); oidcRedirect(w, r, url.Values{"error": {"server_error"}}); return
+    }
+    ident, err := s.oidc.exchange(r.Context(), q.Get("code"), verifier, nonce)
+    if err != nil { log.Println("oidc exchange:", err); oidcRedirect(w, r, url.Values{"error": {"oidc_failed"}}); return }
+    email, isOwner, err := s.linkOIDCIdentity(r.Context(), s.oidc.Name, ident)
+    if err != nil {
+        if errors.Is(err, errOIDCUnverifiedEmail) { oidcRedirect(w, r, url.Values{"error": {"email_not_verified"}}); return }
+        log.Println("oidc link:", err); oidcRedirect(w, r, url.Values{"error": {"server_error"}}); return
+    }
+    if next, err := s.loginSecondFactor(r.Context(), email, isOwner); err != nil { log.Println("oidc 2fa:", err); oidcRedirect(w, r, url.Values{"error": {"server_error"}}); return } else if next != nil {
+        v := url.Values{"challenge": {fmt.Sprint(next["challenge"])}}
+        if next["mfa_required"] == true { v.Set("mfa_required", "true") } else { v.Set("mfa_enrollment_required", "true") }
+        oidcRedirect(w, r, v); return
+    }
+    out, err := s.issueSession(r.Context(), r, email, isOwner)
+    if err != nil { log.Println("oidc session:", err); oidcRedirect(w, r, url.Values{"error": {"server_error"}}); return }
+    s.audit(r, auditEntry{ Actor: email, Action: "auth.login", TargetType: "user", TargetID: email, After: map[string]string{"provider": s.oidc.Name} })
+    oidcRedirect(w, r, url.Values{"token": {fmt.Sprint(out["token"])}, "refresh_token": {fmt.Sprint(out["refresh_token"])}})
+}
+
+var errOIDCUnverifiedEmail = errors.New("email_not_verified")
+
+// linkOIDCIdentity resolves the users row for an identity: an existing link,
+// then an account with the same verified email, else a new guest account.
+func (s *Server) linkOIDCIdentity(ctx context.Context, provider string, id *oidcIdentity) (string, bool, error) {
+    var email string; var isOwner bool
+    err := s.pool.QueryRow(ctx, "SELECT u.email, COALESCE(u.is_owner,false) FROM user_identities i JOIN users u ON u.email = i.user_email WHERE i.provider=$1 AND i.subject=$2", provider, id.Subject).Scan(&email, &isOwner)
+    if err == nil { return email, isOwner, nil }
+    if !errors.Is(err, pgx.ErrNoRows) { return "", false, err }
+    if id.Email == "" || !id.EmailVerified { return "", false, errOIDCUnverifiedEmail }
+    tx, err := s.pool.Begin(ctx)
+    if err != nil { return "", false, err }
+    defer tx.Rollback(ctx)
+    err = tx.QueryRow(ctx, "SELECT email, COALESCE(is_owner,false) FROM users WHERE lower(email)=lower($1)", id.Email).Scan(&email, &isOwner)
+    if errors.Is(err, pgx.ErrNoRows) {
+        // "!" never matches a bcrypt hash, so the account has no password until reset.
+        email, isOwner = id.Email, false
+        _, err = tx.Exec(ctx, "INSERT INTO users (email, password_hash, full_name, is_owner, email_verified) VALUES ($1,'!',$2,FALSE,TRUE)", email, id.Name)
+    } else if err == nil {
+        _, err = tx.Exec(ctx, "UPDATE users SET email_verified=TRUE WHERE email=$1", email)
+    }
+    if err != nil { return "", false, err }
+    if _, err := tx.Exec(ctx, "INSERT INTO user_identities (provider, subject, user_email) VALUES ($1,$2,$3)", provider, id.Subject, email); err != nil { return "", false, err }
+    return email, isOwner, tx.Commit(ctx)
+}
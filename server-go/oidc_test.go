@@ -0,0 +1,175 @@
+package main
+
+import (
+    "context"
+    "crypto/rand"
+    "crypto/rsa"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/json"
+    "math/big"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "strings"
+    "testing"
+    "time"
+    "github.com/golang-jwt/jwt/v5"
+)
+
+// mockIssuer is a local OIDC provider serving discovery, JWKS and a token
+// endpoint that checks the PKCE verifier against the challenge it was sent.
+type mockIssuer struct {
+    srv *httptest.Server
+    key *rsa.PrivateKey
+    signer *rsa.PrivateKey
+    challenge string
+    claims jwt.MapClaims
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+    t.Helper()
+    key, err := rsa.GenerateKey(rand.Reader, 2048)
+    if err != nil { t.Fatal(err) }
+    m := &mockIssuer{ key: key, signer: key }
+    mux := http.NewServeMux()
+    mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+        _ = json.NewEncoder(w).Encode(map[string]string{
+            "issuer": m.srv.URL,
+            "authorization_endpoint": m.srv.URL + "/authorize",
+            "token_endpoint": m.srv.URL + "/token",
+            "jwks_uri": m.srv.URL + "/jwks",
+        })
+    })
+    mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+        pub := m.key.PublicKey
+        _ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+            "kid": "k1", "kty": "RSA", "alg": "RS256",
+            "n": base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+            "e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+        }}})
+    })
+    mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+        _ = r.ParseForm()
+        sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+        if r.PostForm.Get("code") != "good-code" || base64.RawURLEncoding.EncodeToString(sum[:]) != m.challenge || r.PostForm.Get("client_id") != "client-1" {
+            w.WriteHeader(http.StatusBadRequest)
+            _ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+            return
+        }
+        tok := jwt.NewWithClaims(jwt.SigningMethodRS256, m.claims)
+        tok.Header["kid"] = "k1"
+        signed, err := tok.SignedString(m.signer)
+        if err != nil { w.WriteHeader(500); return }
+        _ = json.NewEncoder(w).Encode(map[string]string{"id_token": signed, "access_token": "at"})
+    })
+    m.srv = httptest.NewServer(mux)
+    t.Cleanup(m.srv.Close)
+    return m
+}
+
+func (m *mockIssuer) provider() *OIDCProvider {
+    return &OIDCProvider{ Name: "mock", Issuer: m.srv.URL, ClientID: "client-1", ClientSecret: "secret", RedirectURL: "http://localhost:3005/auth/oidc/callback", Scopes: []string{"openid", "email"}, client: m.srv.Client() }
+}
+
+func (m *mockIssuer) defaultClaims(nonce string) jwt.MapClaims {
+    now := time.Now()
+    return jwt.MapClaims{ "iss": m.srv.URL, "aud": "client-1", "sub": "user-123", "email": "guest@example.com", "email_verified": true, "name": "Guest", "nonce": nonce, "iat": now.Unix(), "exp": now.Add(time.Hour).Unix() }
+}
+
+func TestOIDCAuthURL(t *testing.T) {
+    m := newMockIssuer(t)
+    p := m.provider()
+    d, err := p.config(context.Background())
+    if err != nil { t.Fatal(err) }
+    u, err := url.Parse(p.authURL(d, "state-1", "nonce-1", "verifier-1"))
+    if err != nil { t.Fatal(err) }
+    q := u.Query()
+    sum := sha256.Sum256([]byte("verifier-1"))
+    if u.Path != "/authorize" || q.Get("state") != "state-1" || q.Get("nonce") != "nonce-1" || q.Get("client_id") != "client-1" || q.Get("scope") != "openid email" { t.Errorf("unexpected auth URL %s", u) }
+    if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") != base64.RawURLEncoding.EncodeToString(sum[:]) { t.Errorf("bad PKCE challenge in %s", u) }
+}
+
+func TestOIDCStateCookie(t *testing.T) {
+    rec := httptest.NewRecorder()
+    setOIDCStateCookie(rec, httptest.NewRequest("GET", "/auth/oidc/login", nil), hashToken("state-1"), 600)
+    cookies := rec.Result().Cookies()
+    if len(cookies) != 1 { t.Fatalf("got %d cookies, want 1", len(cookies)) }
+    c := cookies[0]
+    if c.Name != oidcStateCookie || !c.HttpOnly || c.SameSite != http.SameSiteLaxMode || c.MaxAge != 600 { t.Errorf("unexpected cookie %+v", c) }
+    if c.Value == "state-1" { t.Error("cookie holds the raw state") }
+    tests := []struct{ name string; cookie *http.Cookie; state string; want bool }{
+        {"same browser", c, "state-1", true},
+        {"other state", c, "state-2", false},
+        {"no cookie", nil, "state-1", false},
+        {"empty state", &http.Cookie{ Name: oidcStateCookie, Value: hashToken("") }, "", false},
+    }
+    for _, tt := range tests {
+        r := httptest.NewRequest("GET", "/auth/oidc/callback?state="+tt.state, nil)
+        if tt.cookie != nil { r.AddCookie(&http.Cookie{ Name: tt.cookie.Name, Value: tt.cookie.Value }) }
+        if got := oidcStateMatches(r, tt.state); got != tt.want { t.Errorf("%s: oidcStateMatches = %v, want %v", tt.name, got, tt.want) }
+    }
+}
+
+func TestOIDCExchange(t *testing.T) {
+    other, err := rsa.GenerateKey(rand.Reader, 2048)
+    if err != nil { t.Fatal(err) }
+    tests := []struct {
+        name string
+        verifier string
+        edit func(m *mockIssuer, c jwt.MapClaims)
+        wantErr string
+    }{
+        {"ok", "verifier-1", nil, ""},
+        {"string email_verified", "verifier-1", func(m *mockIssuer, c jwt.MapClaims) { c["email_verified"] = "true" }, ""},
+        {"wrong PKCE verifier", "verifier-2", nil, "400"},
+        {"nonce mismatch", "verifier-1", func(m *mockIssuer, c jwt.MapClaims) { c["nonce"] = "someone-elses" }, "nonce mismatch"},
+        {"wrong aud", "verifier-1", func(m *mockIssuer, c jwt.MapClaims) { c["aud"] = "client-2" }, "aud"},
+        {"wrong iss", "verifier-1", func(m *mockIssuer, c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }, "iss"},
+        {"expired", "verifier-1", func(m *mockIssuer, c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, "expired"},
+        {"no exp", "verifier-1", func(m *mockIssuer, c jwt.MapClaims) { delete(c, "exp") }, "exp"},
+        {"signed by another key", "verifier-1", func(m *mockIssuer, c jwt.MapClaims) { m.signer = other }, "signature"},
+        {"missing sub", "verifier-1", func(m *mockIssuer, c jwt.MapClaims) { delete(c, "sub") }, "missing sub"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            m := newMockIssuer(t)
+            p := m.provider()
+            d, err := p.config(context.Background())
+            if err != nil { t.Fatal(err) }
+            u, _ := url.Parse(p.authURL(d, "state-1", "nonce-1", "verifier-1"))
+            m.challenge = u.Query().Get("code_challenge")
+            m.claims = m.defaultClaims("nonce-1")
+            if tt.edit != nil { tt.edit(m, m.claims) }
+            id, err := p.exchange(context.Background(), "good-code", tt.verifier, "nonce-1")
+            if tt.wantErr != "" {
+                if err == nil || !strings.Contains(err.Error(), tt.wantErr) { t.Fatalf("err = %v, want one mentioning %q", err, tt.wantErr) }
+                return
+            }
+            if err != nil { t.Fatal(err) }
+            if id.Subject != "user-123" || id.Email != "guest@example.com" || !id.EmailVerified || id.Name != "Guest" { t.Errorf("identity = %+v", id) }
+        })
+    }
+}
+
+func TestOIDCIssuerMismatch(t *testing.T) {
+    m := newMockIssuer(t)
+    p := m.provider()
+    p.Issuer = "https://accounts.example.com"
+    // Discovery is fetched from the configured issuer, so point it at the
+    // mock through a redirecting transport.
+    p.client = &http.Client{ Transport: rewriteTransport{ to: m.srv.URL, base: m.srv.Client().Transport } }
+    if _, err := p.config(context.Background()); err == nil || !strings.Contains(err.Error(), "issuer mismatch") { t.Fatalf("err = %v, want issuer mismatch", err) }
+}
+
+type rewriteTransport struct {
+    to string
+    base http.RoundTripper
+}
+
+func (rt rewriteTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+    u, _ := url.Parse(rt.to)
+    r = r.Clone(r.Context())
+    r.URL.Scheme, r.URL.Host = u.Scheme, u.Host
+    return rt.base.RoundTrip(r)
+}
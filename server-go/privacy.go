@@ -0,0 +1,184 @@
+package main
+
+import (
+    "archive/zip"
+    "context"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "net/url"
+    "time"
+    "github.com/gorilla/mux"
+    "github.com/jackc/pgx/v5"
+)
+
+const anonymizedGuestName = "Hóspede anonimizado"
+
+var errActiveBookings = errors.New("active_bookings")
+
+// queryJSONRows returns the rows of a query as JSON objects keyed by column name.
+func (s *Server) queryJSONRows(ctx context.Context, sql string, args ...any) ([]map[string]any, error) {
+    rows, err := s.pool.Query(ctx, sql, args...)
+    if err != nil { return nil, err }
+    defer rows.Close()
+    out := []map[string]any{}
+    for rows.Next() {
+        vals, err := rows.Values()
+        if err != nil { return nil, err }
+        rec := make(map[string]any, len(vals))
+        for i, f := range rows.FieldDescriptions() { rec[f.Name] = vals[i] }
+        out = append(out, rec)
+    }
+    return out, rows.Err()
+}
+
+// bookingOwnershipSQL matches a data subject's bookings. Bookings tied to a
+// guest_email only count when that email has been proven.
+const bookingOwnershipSQL = "(user_email=$1 OR ($2 AND lower(guest_email)=lower($1)))"
+
+// erasureOwnershipSQL matches bookings to erase. Erasing only removes data,
+// so guest_email matches count whether or not the email was verified.
+const erasureOwnershipSQL = "(user_email=$1 OR lower(guest_email)=lower($1))"
+
+// collectPersonalData gathers everything stored about email for an LGPD access request.
+func (s *Server) collectPersonalData(ctx context.Context, email string, emailProven bool) (map[string]any, error) {
+    out := map[string]any{"generated_at": time.Now().UTC(), "subject": email}
+    queries := []struct{ name, sql string; args []any }{
+        {"user", "SELECT email, full_name, is_owner, email_verified, totp_enabled, created_at FROM users WHERE email=$1", []any{email}},
+        {"bookings", "SELECT id::text, status, check_in, check_out, guest_name, guest_email, guest_phone, number_of_guests, subtotal_price::float8, discount_amount::float8, total_price::float8, created_at, updated_at FROM bookings WHERE " + bookingOwnershipSQL + " ORDER BY created_at", []any{email, emailProven}},
+        {"messages", "SELECT id, booking_id::text, sender_email, is_from_owner, message, created_at FROM messages WHERE sender_email=$1 OR booking_id IN (SELECT id FROM bookings WHERE " + bookingOwnershipSQL + ") ORDER BY created_at", []any{email, emailProven}},
+        {"notification_preferences", "SELECT phone, channels, opted_out, updated_at FROM notification_prefs WHERE user_email=$1", []any{email}},
+        {"sessions", "SELECT id::text, user_agent, ip, created_at, last_used_at, expires_at, revoked_at FROM sessions WHERE user_email=$1 ORDER BY created_at", []any{email}},
+        {"linked_identities", "SELECT provider, subject, created_at FROM user_identities WHERE user_email=$1", []any{email}},
+        {"api_keys", "SELECT id, name, scopes, expires_at, last_used_at, revoked_at, created_at FROM api_keys WHERE owner_email=$1", []any{email}},
+        {"app_passwords", "SELECT id, name, last_used_at, revoked_at, created_at FROM app_passwords WHERE owner_email=$1", []any{email}},
+    }
+    for _, q := range queries {
+        rows, err := s.queryJSONRows(ctx, q.sql, q.args...)
+        if err != nil { return nil, err }
+        out[q.name] = rows
+    }
+    // The site doesn't store attachments yet; the key keeps the export format stable.
+    out["attachments"] = []any{}
+    return out, nil
+}
+
+func writePersonalDataExport(w http.ResponseWriter, r *http.Request, email string, data map[string]any) {
+    if r.URL.Query().Get("format") == "json" {
+        w.Header().Set("Content-Disposition", `attachment; filename="export.json"`)
+        jsonResp(w, 200, data)
+        return
+    }
+    w.Header().Set("Content-Type", "application/zip")
+    w.Header().Set("Content-Disposition", `attachment; filename="export-`+url.PathEscape(email)+`.zip"`)
+    zw := zip.NewWriter(w)
+    for name, v := range data {
+        f, err := zw.Create(name + ".json")
+        if err != nil { return }
+        enc := json.NewEncoder(f)
+        enc.SetIndent("", "  ")
+        if err := enc.Encode(v); err != nil { return }
+    }
+    _ = zw.Close()
+}
+
+// erasePersonalData anonymizes a data subject's bookings (keeping prices for
+// accounting), deletes their messages and credentials, ends their sessions and
+// redacts them from the audit log.
+// Unless force is set it refuses while the subject has upcoming stays.
+func (s *Server) erasePersonalData(ctx context.Context, email string, force bool) (map[string]int64, error) {
+    tx, err := s.pool.Begin(ctx)
+    if err != nil { return nil, err }
+    defer tx.Rollback(ctx)
+    var active int64
+    if err := tx.QueryRow(ctx, "SELECT COUNT(*) FROM bookings WHERE "+erasureOwnershipSQL+" AND COALESCE(status,'requested') <> 'rejected' AND check_out > now()", email).Scan(&active); err != nil { return nil, err }
+    if active > 0 && !force { return nil, errActiveBookings }
+    summary := map[string]int64{}
+    steps := []struct{ name, sql string; args []any }{
+        {"messages_deleted", "DELETE FROM messages WHERE sender_email=$1 OR booking_id IN (SELECT id FROM bookings WHERE " + erasureOwnershipSQL + ")", []any{email}},
+        {"bookings_anonymized", "UPDATE bookings SET guest_name=$2, guest_email=NULL, guest_phone=NULL, user_email=NULL, status=CASE WHEN check_out > now() AND COALESCE(status,'requested') <> 'rejected' THEN 'rejected' ELSE status END, updated_at=now() WHERE " + erasureOwnershipSQL, []any{email, anonymizedGuestName}},
+        {"sessions_revoked", "DELETE FROM sessions WHERE user_email=$1", []any{email}},
+        {"api_keys_deleted", "DELETE FROM api_keys WHERE owner_email=$1", []any{email}},
+        {"app_passwords_deleted", "DELETE FROM app_passwords WHERE owner_email=$1", []any{email}},
+        {"notification_prefs_deleted", "DELETE FROM notification_prefs WHERE user_email=$1", []any{email}},
+        {"identities_deleted", "DELETE FROM user_identities WHERE user_email=$1", []any{email}},
+        {"auth_tokens_deleted", "DELETE FROM auth_tokens WHERE user_email=$1", []any{email}},
+        {"mfa_deleted", "DELETE FROM mfa_challenges WHERE user_email=$1", []any{email}},
+        {"recovery_codes_deleted", "DELETE FROM totp_recovery_codes WHERE user_email=$1", []any{email}},
+        {"users_deleted", "DELETE FROM users WHERE email=$1", []any{email}},
+    }
+    for _, st := range steps {
+        tag, err := tx.Exec(ctx, st.sql, st.args...)
+        if err != nil { return nil, err }
+        summary[st.name] = tag.RowsAffected()
+    }
+    n, err := s.redactAuditSubject(ctx, tx, email)
+    if err != nil { return nil, err }
+    summary["audit_events_redacted"] = n
+    if err := tx.Commit(ctx); err != nil { return nil, err }
+    return summary, nil
+}
+
+func (s *Server) emailVerified(ctx context.Context, email string) (bool, error) {
+    var v bool
+    err := s.pool.QueryRow(ctx, "SELECT email_verified FROM users WHERE email=$1", email).Scan(&v)
+    if errors.Is(err, pgx.ErrNoRows) { return false, nil }
+    return v, err
+}
+
+func (s *Server) handleExportMe(w http.ResponseWriter, r *http.Request) {
+    email, _ := getClaims(r)["email"].(string)
+    verified, err := s.emailVerified(r.Context(), email)
+    if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    data, err := s.collectPersonalData(r.Context(), email, verified)
+    if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    writePersonalDataExport(w, r, email, data)
+}
+
+// handleDeleteMe erases the caller's account after re-checking their password,
+// or for accounts without one (OIDC), that they signed in moments ago.
+// Owners must be removed by another owner so the property is never left unmanaged.
+func (s *Server) handleDeleteMe(w http.ResponseWriter, r *http.Request) {
+    email, _ := getClaims(r)["email"].(string)
+    var body struct{ Password string }
+    _ = json.NewDecoder(r.Body).Decode(&body)
+    var isOwner bool
+    if err := s.pool.QueryRow(r.Context(), "SELECT COALESCE(is_owner,false) FROM users WHERE email=$1", email).Scan(&isOwner); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    if isOwner { jsonResp(w, 409, map[string]string{"error":"owner_account"}); return }
+    sid, _ := getClaims(r)["sid"].(string)
+    if !s.requireReauth(w, r, email, sid, body.Password) { return }
+    summary, err := s.erasePersonalData(r.Context(), email, false)
+    if err != nil {
+        if errors.Is(err, errActiveBookings) { jsonResp(w, 409, map[string]string{"error":"active_bookings"}); return }
+        jsonResp(w, 500, map[string]string{"error": err.Error()}); return
+    }
+    s.revokeAccessToken(r.Context(), getClaims(r))
+    s.invalidateAvailability()
+    s.audit(r, auditEntry{ Action: "user.erase", TargetType: "user", TargetID: email, After: summary })
+    jsonResp(w, 200, map[string]any{"success": true, "summary": summary})
+}
+
+// Owner endpoints for requests received outside the site (email, phone).
+// The owner has verified the requester, so guest_email matches are included.
+func (s *Server) handleAdminExportUser(w http.ResponseWriter, r *http.Request) {
+    if !s.requireOwner(w, r) { return }
+    email := mux.Vars(r)["email"]
+    data, err := s.collectPersonalData(r.Context(), email, true)
+    if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    s.audit(r, auditEntry{ Action: "user.export", TargetType: "user", TargetID: email })
+    writePersonalDataExport(w, r, email, data)
+}
+
+func (s *Server) handleAdminDeleteUser(w http.ResponseWriter, r *http.Request) {
+    if !s.requireOwner(w, r) { return }
+    email := mux.Vars(r)["email"]
+    if email == getClaims(r)["email"] { jsonResp(w, 409, map[string]string{"error":"cannot_delete_self"}); return }
+    summary, err := s.erasePersonalData(r.Context(), email, r.URL.Query().Get("force") == "true")
+    if err != nil {
+        if errors.Is(err, errActiveBookings) { jsonResp(w, 409, map[string]string{"error":"active_bookings"}); return }
+        jsonResp(w, 500, map[string]string{"error": err.Error()}); return
+    }
+    s.invalidateAvailability()
+    s.audit(r, auditEntry{ Action: "user.erase", TargetType: "user", TargetID: email, After: summary })
+    jsonResp(w, 200, map[string]any{"success": true, "summary": summary})
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+    "archive/zip"
+    "bytes"
+    "encoding/json"
+    "net/http/httptest"
+    "sort"
+    "testing"
+)
+
+func TestWritePersonalDataExport(t *testing.T) {
+    data := map[string]any{"user": []map[string]any{{"email": "g@example.com"}}, "attachments": []any{}}
+
+    rec := httptest.NewRecorder()
+    writePersonalDataExport(rec, httptest.NewRequest("GET", "/me/export?format=json", nil), "g@example.com", data)
+    var got map[string]any
+    if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil { t.Fatal(err) }
+    if len(got) != 2 || got["user"] == nil { t.Errorf("json export = %v", got) }
+
+    rec = httptest.NewRecorder()
+    writePersonalDataExport(rec, httptest.NewRequest("GET", "/me/export", nil), "g@example.com", data)
+    if ct := rec.Header().Get("Content-Type"); ct != "application/zip" { t.Errorf("Content-Type = %q", ct) }
+    if cd := rec.Header().Get("Content-Disposition"); cd != `attachment; filename="export-g@example.com.zip"` { t.Errorf("Content-Disposition = %q", cd) }
+    zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+    if err != nil { t.Fatal(err) }
+    var names []string
+    for _, f := range zr.File { names = append(names, f.Name) }
+    sort.Strings(names)
+    if len(names) != 2 || names[0] != "attachments.json" || names[1] != "user.json" { t.Errorf("zip files = %v", names) }
+}
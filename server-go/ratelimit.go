@@ -0,0 +1,226 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "io"
+    "math"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+    "github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+    lockoutThreshold = 5
+    lockoutBase = time.Minute
+    lockoutMax = time.Hour
+    // failures older than this no longer count toward a lockout
+    lockoutMemory = time.Hour
+)
+
+// RateLimitStore keeps fixed-window request counters and login failure state.
+type RateLimitStore interface {
+    // Allow counts one hit for key and reports whether it is within limit.
+    Allow(ctx context.Context, key string, limit int, window time.Duration) (bool, time.Duration, error)
+    // RecordFailure counts a failed login for key and returns how long it is now locked.
+    RecordFailure(ctx context.Context, key string) (time.Duration, error)
+    LockedFor(ctx context.Context, key string) (time.Duration, error)
+    ResetFailures(ctx context.Context, key string) error
+}
+
+// lockoutFor doubles the lockout for every failure past the threshold.
+func lockoutFor(failures int) time.Duration {
+    if failures < lockoutThreshold { return 0 }
+    d := lockoutBase * time.Duration(math.Pow(2, float64(failures-lockoutThreshold)))
+    if d > lockoutMax || d <= 0 { return lockoutMax }
+    return d
+}
+
+func newRateLimitStore(pool *pgxpool.Pool) RateLimitStore {
+    if os.Getenv("RATE_LIMIT_BACKEND") == "postgres" { return &PostgresRateLimitStore{ pool: pool } }
+    return NewMemoryRateLimitStore()
+}
+
+// MemoryRateLimitStore is suitable for a single instance.
+type MemoryRateLimitStore struct {
+    mu sync.Mutex
+    windows map[string]*memWindow
+    failures map[string]*memFailures
+    lastSweep time.Time
+}
+
+type memWindow struct { start time.Time; window time.Duration; count int }
+type memFailures struct { count int; last time.Time; lockedUntil time.Time }
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+    return &MemoryRateLimitStore{ windows: make(map[string]*memWindow), failures: make(map[string]*memFailures), lastSweep: time.Now() }
+}
+
+func (m *MemoryRateLimitStore) sweep(now time.Time) {
+    if now.Sub(m.lastSweep) < time.Minute { return }
+    m.lastSweep = now
+    for k, w := range m.windows { if now.Sub(w.start) >= w.window { delete(m.windows, k) } }
+    for k, f := range m.failures { if now.Sub(f.last) >= lockoutMemory && now.After(f.lockedUntil) { delete(m.failures, k) } }
+}
+
+func (m *MemoryRateLimitStore) Allow(ctx context.Context, key string, limit int, window time.Duration) (bool, time.Duration, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    now := time.Now()
+    m.sweep(now)
+    w, ok := m.windows[key]
+    if !ok || now.Sub(w.start) >= window {
+        w = &memWindow{ start: now, window: window }
+        m.windows[key] = w
+    }
+    w.count++
+    if w.count > limit { return false, w.start.Add(window).Sub(now), nil }
+    return true, 0, nil
+}
+
+func (m *MemoryRateLimitStore) RecordFailure(ctx context.Context, key string) (time.Duration, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    now := time.Now()
+    f, ok := m.failures[key]
+    if !ok || now.Sub(f.last) >= lockoutMemory { f = &memFailures{}; m.failures[key] = f }
+    f.count++
+    f.last = now
+    d := lockoutFor(f.count)
+    if d > 0 { f.lockedUntil = now.Add(d) }
+    return d, nil
+}
+
+func (m *MemoryRateLimitStore) LockedFor(ctx context.Context, key string) (time.Duration, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    if f, ok := m.failures[key]; ok && time.Now().Before(f.lockedUntil) { return time.Until(f.lockedUntil), nil }
+    return 0, nil
+}
+
+func (m *MemoryRateLimitStore) ResetFailures(ctx context.Context, key string) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    delete(m.failures, key)
+    return nil
+}
+
+// PostgresRateLimitStore shares counters between instances.
+type PostgresRateLimitStore struct {
+    pool *pgxpool.Pool
+    mu sync.Mutex
+    lastSweep time.Time
+}
+
+// sweep deletes expired windows and forgotten failures, at most once a
+// minute per instance, so the tables only hold live state.
+func (p *PostgresRateLimitStore) sweep(ctx context.Context) error {
+    p.mu.Lock()
+    if time.Since(p.lastSweep) < time.Minute { p.mu.Unlock(); return nil }
+    p.lastSweep = time.Now()
+    p.mu.Unlock()
+    if _, err := p.pool.Exec(ctx, "DELETE FROM rate_limits WHERE window_end <= now()"); err != nil { return err }
+    _, err := p.pool.Exec(ctx, "DELETE FROM login_failures WHERE last_failure_at < now() - $1 * interval '1 second' AND (locked_until IS NULL OR locked_until <= now())", int64(lockoutMemory/time.Second))
+    return err
+}
+
+func (p *PostgresRateLimitStore) Allow(ctx context.Context, key string, limit int, window time.Duration) (bool, time.Duration, error) {
+    if err := p.sweep(ctx); err != nil { return false, 0, err }
+    var count int; var reset time.Time
+    err := p.pool.QueryRow(ctx, `INSERT INTO rate_limits (key, count, window_start, window_end) VALUES ($1, 1, now(), now() + $2 * interval '1 millisecond')
+ON CONFLICT (key) DO UPDATE SET
+  count = CASE WHEN rate_limits.window_end <= now() THEN 1 ELSE rate_limits.count + 1 END,
+  window_start = CASE WHEN rate_limits.window_end <= now() THEN now() ELSE rate_limits.window_start END,
+  window_end = CASE WHEN rate_limits.window_end <= now() THEN now() + $2 * interval '1 millisecond' ELSE rate_limits.window_end END
+RETURNING count, window_end`, key, window.Milliseconds()).Scan(&count, &reset)
+    if err != nil { return false, 0, err }
+    if count > limit { return false, time.Until(reset), nil }
+    return true, 0, nil
+}
+
+func (p *PostgresRateLimitStore) RecordFailure(ctx context.Context, key string) (time.Duration, error) {
+    if err := p.sweep(ctx); err != nil { return 0, err }
+    var failures int
+    err := p.pool.QueryRow(ctx, `INSERT INTO login_failures (key, failures, last_failure_at) VALUES ($1, 1, now())
+ON CONFLICT (key) DO UPDATE SET
+  failures = CASE WHEN login_failures.last_failure_at < now() - $2 * interval '1 second' THEN 1 ELSE login_failures.failures + 1 END,
+  last_failure_at = now()
+RETURNING failures`, key, int64(lockoutMemory/time.Second)).Scan(&failures)
+    if err != nil { return 0, err }
+    d := lockoutFor(failures)
+    if d > 0 {
+        if _, err := p.pool.Exec(ctx, "UPDATE login_failures SET locked_until = now() + $2 * interval '1 millisecond' WHERE key=$1", key, d.Milliseconds()); err != nil { return 0, err }
+    }
+    return d, nil
+}
+
+func (p *PostgresRateLimitStore) LockedFor(ctx context.Context, key string) (time.Duration, error) {
+    var ms float64
+    err := p.pool.QueryRow(ctx, "SELECT COALESCE(MAX(EXTRACT(EPOCH FROM (locked_until - now())) * 1000), 0)::float8 FROM login_failures WHERE key=$1 AND locked_until > now()", key).Scan(&ms)
+    if err != nil { return 0, err }
+    return time.Duration(ms) * time.Millisecond, nil
+}
+
+func (p *PostgresRateLimitStore) ResetFailures(ctx context.Context, key string) error {
+    _, err := p.pool.Exec(ctx, "DELETE FROM login_failures WHERE key=$1", key)
+    return err
+}
+
+// rateRule limits requests sharing the same key. A rule whose Key returns ""
+// is skipped for that request.
+type rateRule struct {
+    Name string
+    Limit int
+    Window time.Duration
+    Key func(r *http.Request) string
+}
+
+func byIP(r *http.Request) string { return clientIP(r) }
+
+// byBodyEmail keys on the "email" (or guest email) field of a JSON body,
+// restoring the body for the handler.
+func byBodyEmail(r *http.Request) string {
+    if r.Body == nil { return "" }
+    raw, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+    r.Body.Close()
+    r.Body = io.NopCloser(bytes.NewReader(raw))
+    if err != nil { return "" }
+    var body struct{ Email string; GuestEmail string }
+    _ = json.Unmarshal(raw, &body)
+    if body.Email == "" { body.Email = body.GuestEmail }
+    return strings.ToLower(strings.TrimSpace(body.Email))
+}
+
+func writeRateLimited(w http.ResponseWriter, retry time.Duration) {
+    secs := int(math.Ceil(retry.Seconds()))
+    if secs < 1 { secs = 1 }
+    w.Header().Set("Retry-After", strconv.Itoa(secs))
+    jsonResp(w, http.StatusTooManyRequests, map[string]any{"error": "rate_limited", "retry_after": secs})
+}
+
+// rateLimit wraps a route with per-IP and per-account buckets.
+func (s *Server) rateLimit(next http.Handler, rules ...rateRule) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        for _, rule := range rules {
+            k := rule.Key(r)
+            if k == "" { continue }
+            ok, retry, err := s.limiter.Allow(r.Context(), rule.Name+":"+k, rule.Limit, rule.Window)
+            if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+            if !ok { writeRateLimited(w, retry); return }
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+var (
+    loginRules = []rateRule{{"login-ip", 20, 5 * time.Minute, byIP}, {"login-account", 10, 5 * time.Minute, byBodyEmail}}
+    registerRules = []rateRule{{"register-ip", 5, time.Hour, byIP}}
+    forgotRules = []rateRule{{"forgot-ip", 5, 15 * time.Minute, byIP}, {"forgot-account", 3, time.Hour, byBodyEmail}}
+    bookingRules = []rateRule{{"booking-ip", 10, time.Hour, byIP}, {"booking-account", 5, time.Hour, byBodyEmail}}
+    availabilityRules = []rateRule{{"availability-ip", 60, time.Minute, byIP}}
+)
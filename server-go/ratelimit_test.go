@@ -0,0 +1,64 @@
+package main
+
+import (
+    "context"
+    "io"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestLockoutFor(t *testing.T) {
+    tests := []struct{ failures int; want time.Duration }{
+        {0, 0},
+        {lockoutThreshold - 1, 0},
+        {lockoutThreshold, lockoutBase},
+        {lockoutThreshold + 1, 2 * lockoutBase},
+        {lockoutThreshold + 3, 8 * lockoutBase},
+        {lockoutThreshold + 6, lockoutMax},
+        {1000, lockoutMax},
+    }
+    for _, tt := range tests {
+        if got := lockoutFor(tt.failures); got != tt.want { t.Errorf("lockoutFor(%d) = %v, want %v", tt.failures, got, tt.want) }
+    }
+}
+
+func TestMemoryRateLimitAllow(t *testing.T) {
+    m := NewMemoryRateLimitStore()
+    ctx := context.Background()
+    for i := 1; i <= 4; i++ {
+        ok, retry, _ := m.Allow(ctx, "login-ip:1.2.3.4", 3, time.Minute)
+        if want := i <= 3; ok != want { t.Fatalf("hit %d: allowed=%v, want %v", i, ok, want) }
+        if !ok && (retry <= 0 || retry > time.Minute) { t.Errorf("hit %d: retry %v out of range", i, retry) }
+    }
+    if ok, _, _ := m.Allow(ctx, "login-ip:5.6.7.8", 3, time.Minute); !ok { t.Error("other keys share the bucket") }
+    if ok, _, _ := m.Allow(ctx, "short", 1, time.Millisecond); !ok { t.Fatal("first hit refused") }
+    time.Sleep(2 * time.Millisecond)
+    if ok, _, _ := m.Allow(ctx, "short", 1, time.Millisecond); !ok { t.Error("window didn't reset") }
+}
+
+func TestMemoryRateLimitLockout(t *testing.T) {
+    m := NewMemoryRateLimitStore()
+    ctx := context.Background()
+    for i := 1; i <= lockoutThreshold+1; i++ {
+        d, _ := m.RecordFailure(ctx, "login:a@example.com")
+        if d != lockoutFor(i) { t.Errorf("failure %d: locked %v, want %v", i, d, lockoutFor(i)) }
+    }
+    if d, _ := m.LockedFor(ctx, "login:a@example.com"); d <= lockoutBase { t.Errorf("LockedFor = %v, want about %v", d, 2*lockoutBase) }
+    _ = m.ResetFailures(ctx, "login:a@example.com")
+    if d, _ := m.LockedFor(ctx, "login:a@example.com"); d != 0 { t.Errorf("still locked after reset: %v", d) }
+}
+
+func TestByBodyEmail(t *testing.T) {
+    tests := []struct{ body, want string }{
+        {`{"email":" A@Example.com "}`, "a@example.com"},
+        {`{"GuestEmail":"g@example.com"}`, "g@example.com"},
+        {`not json`, ""},
+    }
+    for _, tt := range tests {
+        r := httptest.NewRequest("POST", "/auth/login", strings.NewReader(tt.body))
+        if got := byBodyEmail(r); got != tt.want { t.Errorf("byBodyEmail(%s) = %q, want %q", tt.body, got, tt.want) }
+        if rest, _ := io.ReadAll(r.Body); string(rest) != tt.body { t.Errorf("body not restored: %q", rest) }
+    }
+}
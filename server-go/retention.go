@@ -0,0 +1,165 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "log"
+    "net/http"
+    "os"
+    "strconv"
+    "time"
+    "github.com/jackc/pgx/v5"
+)
+
+// retentionLockID keeps concurrent instances from purging at the same time.
+const retentionLockID = 73310036
+
+// RetentionPolicy says how long guest PII is kept. Zero disables a rule.
+type RetentionPolicy struct {
+    AnonymizeContactAfterMonths int `json:"anonymize_contact_after_months"`
+    DeleteMessagesAfterMonths int `json:"delete_messages_after_months"`
+    RedactAuditAfterMonths int `json:"redact_audit_after_months"`
+}
+
+func (p RetentionPolicy) valid() bool {
+    return p.AnonymizeContactAfterMonths >= 0 && p.DeleteMessagesAfterMonths >= 0 && p.RedactAuditAfterMonths >= 0
+}
+
+type RetentionReport struct {
+    DryRun bool `json:"dry_run"`
+    Policy RetentionPolicy `json:"policy"`
+    BookingIDs []string `json:"booking_ids"`
+    MessagesDeleted int64 `json:"messages_deleted"`
+    AuditEventsRedacted int64 `json:"audit_events_redacted"`
+    RunID int64 `json:"run_id,omitempty"`
+}
+
+func (s *Server) retentionPolicy(ctx context.Context) (RetentionPolicy, error) {
+    var p RetentionPolicy
+    var raw []byte
+    err := s.pool.QueryRow(ctx, "SELECT value FROM app_settings WHERE key='retention'").Scan(&raw)
+    if errors.Is(err, pgx.ErrNoRows) { return p, nil }
+    if err != nil { return p, err }
+    return p, json.Unmarshal(raw, &p)
+}
+
+// applyRetention anonymizes contact data on bookings whose checkout is older
+// than the policy allows, deletes old messages and redacts people from old
+// audit events through audit_redact_before. With dryRun it only reports
+// what would change. Every real run is written to retention_runs.
+func (s *Server) applyRetention(ctx context.Context, triggeredBy string, dryRun bool) (*RetentionReport, error) {
+    p, err := s.retentionPolicy(ctx)
+    if err != nil { return nil, err }
+    rep := &RetentionReport{ DryRun: dryRun, Policy: p, BookingIDs: []string{} }
+    tx, err := s.pool.Begin(ctx)
+    if err != nil { return nil, err }
+    defer tx.Rollback(ctx)
+    if !dryRun {
+        var locked bool
+        if err := tx.QueryRow(ctx, "SELECT pg_try_advisory_xact_lock($1)", retentionLockID).Scan(&locked); err != nil { return nil, err }
+        if !locked { return nil, errors.New("retention_already_running") }
+    }
+    if p.AnonymizeContactAfterMonths > 0 {
+        sel := "SELECT id::text FROM bookings WHERE check_out < now() - make_interval(months => $1) AND (guest_email IS NOT NULL OR guest_phone IS NOT NULL OR guest_name IS DISTINCT FROM $2)"
+        if !dryRun { sel = "UPDATE bookings SET guest_name=$2, guest_email=NULL, guest_phone=NULL, updated_at=now() WHERE id IN (" + sel + ") RETURNING id::text" }
+        rows, err := tx.Query(ctx, sel, p.AnonymizeContactAfterMonths, anonymizedGuestName)
+        if err != nil { return nil, err }
+        for rows.Next() {
+            var id string
+            if err := rows.Scan(&id); err != nil { rows.Close(); return nil, err }
+            rep.BookingIDs = append(rep.BookingIDs, id)
+        }
+        rows.Close()
+        if rows.Err() != nil { return nil, rows.Err() }
+    }
+    if p.DeleteMessagesAfterMonths > 0 {
+        if dryRun {
+            if err := tx.QueryRow(ctx, "SELECT COUNT(*) FROM messages WHERE created_at < now() - make_interval(months => $1)", p.DeleteMessagesAfterMonths).Scan(&rep.MessagesDeleted); err != nil { return nil, err }
+        } else {
+            tag, err := tx.Exec(ctx, "DELETE FROM messages WHERE created_at < now() - make_interval(months => $1)", p.DeleteMessagesAfterMonths)
+            if err != nil { return nil, err }
+            rep.MessagesDeleted = tag.RowsAffected()
+        }
+    }
+    if p.RedactAuditAfterMonths > 0 {
+        q := "SELECT COUNT(*) FROM audit_events WHERE created_at < now() - make_interval(months => $1) AND redacted_at IS NULL"
+        if !dryRun { q = "SELECT audit_redact_before(now() - make_interval(months => $1))" }
+        if err := tx.QueryRow(ctx, q, p.RedactAuditAfterMonths).Scan(&rep.AuditEventsRedacted); err != nil { return nil, err }
+    }
+    if dryRun { return rep, nil }
+    policy, _ := json.Marshal(p)
+    if err := tx.QueryRow(ctx, "INSERT INTO retention_runs (triggered_by, policy, booking_ids, messages_deleted, audit_events_redacted) VALUES ($1,$2,$3,$4,$5) RETURNING id", triggeredBy, policy, rep.BookingIDs, rep.MessagesDeleted, rep.AuditEventsRedacted).Scan(&rep.RunID); err != nil { return nil, err }
+    if err := tx.Commit(ctx); err != nil { return nil, err }
+    return rep, nil
+}
+
+// retentionInterval is RETENTION_INTERVAL, default 24h.
+func retentionInterval() time.Duration {
+    if d, err := time.ParseDuration(os.Getenv("RETENTION_INTERVAL")); err == nil && d > 0 { return d }
+    return 24 * time.Hour
+}
+
+// runRetentionJob applies the policy on a schedule.
+func (s *Server) runRetentionJob(ctx context.Context) {
+    t := time.NewTicker(retentionInterval())
+    defer t.Stop()
+    for {
+        select {
+        case <-ctx.Done(): return
+        case <-t.C:
+        }
+        rep, err := s.applyRetention(ctx, "schedule", false)
+        if err != nil { log.Println("retention:", err); continue }
+        s.auditSystem(ctx, auditEntry{ Action: "retention.run", TargetType: "retention_run", TargetID: strconv.FormatInt(rep.RunID, 10), After: rep })
+        if len(rep.BookingIDs) > 0 || rep.MessagesDeleted > 0 || rep.AuditEventsRedacted > 0 { log.Printf("retention: %d reservas anonimizadas, %d mensagens removidas, %d eventos de auditoria redigidos", len(rep.BookingIDs), rep.MessagesDeleted, rep.AuditEventsRedacted) }
+    }
+}
+
+func (s *Server) handleGetRetentionPolicy(w http.ResponseWriter, r *http.Request) {
+    if !s.requireOwner(w, r) { return }
+    p, err := s.retentionPolicy(r.Context())
+    if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    jsonResp(w, 200, p)
+}
+
+func (s *Server) handlePutRetentionPolicy(w http.ResponseWriter, r *http.Request) {
+    if !s.requireOwner(w, r) { return }
+    var p RetentionPolicy
+    _ = json.NewDecoder(r.Body).Decode(&p)
+    if !p.valid() { jsonResp(w, 400, map[string]string{"error":"invalid_input"}); return }
+    before, err := s.retentionPolicy(r.Context())
+    if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    raw, _ := json.Marshal(p)
+    if _, err := s.pool.Exec(r.Context(), "INSERT INTO app_settings (key, value) VALUES ('retention', $1) ON CONFLICT (key) DO UPDATE SET value=EXCLUDED.value, updated_at=now()", raw); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    s.audit(r, auditEntry{ Action: "settings.retention", TargetType: "settings", TargetID: "retention", Before: before, After: p })
+    jsonResp(w, 200, p)
+}
+
+// handleRetentionPreview is the dry-run report of what the next run would purge.
+func (s *Server) handleRetentionPreview(w http.ResponseWriter, r *http.Request) {
+    if !s.requireOwner(w, r) { return }
+    rep, err := s.applyRetention(r.Context(), "", true)
+    if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    jsonResp(w, 200, rep)
+}
+
+func (s *Server) handleRetentionRun(w http.ResponseWriter, r *http.Request) {
+    if !s.requireOwner(w, r) { return }
+    email, _ := getClaims(r)["email"].(string)
+    rep, err := s.applyRetention(r.Context(), email, false)
+    if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    s.audit(r, auditEntry{ Action: "retention.run", TargetType: "retention_run", TargetID: strconv.FormatInt(rep.RunID, 10), After: rep })
+    jsonResp(w, 200, rep)
+}
+
+func (s *Server) handleListRetentionRuns(w http.ResponseWriter, r *http.Request) {
+    if !s.requireOwner(w, r) { return }
+    rows, err := s.pool.Query(r.Context(), "SELECT id, triggered_by, policy::text, booking_ids::text[], messages_deleted, audit_events_redacted, created_at FROM retention_runs ORDER BY id DESC LIMIT 100")
+    if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    type rec struct{ ID int64 `json:"id"`; TriggeredBy string `json:"triggered_by"`; Policy json.RawMessage `json:"policy"`; BookingIDs []string `json:"booking_ids"`; MessagesDeleted int64 `json:"messages_deleted"`; AuditEventsRedacted int64 `json:"audit_events_redacted"`; CreatedAt time.Time `json:"created_at"` }
+    var out []rec
+    for rows.Next() { var a rec; var policy string; if err := rows.Scan(&a.ID,&a.TriggeredBy,&policy,&a.BookingIDs,&a.MessagesDeleted,&a.AuditEventsRedacted,&a.CreatedAt); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return } ; a.Policy = json.RawMessage(policy); out = append(out,a) }
+    if rows.Err() != nil { jsonResp(w, 500, map[string]string{"error": rows.Err().Error()}); return }
+    jsonResp(w, 200, map[string]any{"data": out})
+}
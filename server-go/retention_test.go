@@ -0,0 +1,32 @@
+package main
+
+import (
+    "testing"
+    "time"
+)
+
+func TestRetentionPolicyValid(t *testing.T) {
+    tests := []struct{ p RetentionPolicy; want bool }{
+        {RetentionPolicy{}, true},
+        {RetentionPolicy{ AnonymizeContactAfterMonths: 24, DeleteMessagesAfterMonths: 12, RedactAuditAfterMonths: 60 }, true},
+        {RetentionPolicy{ AnonymizeContactAfterMonths: -1 }, false},
+        {RetentionPolicy{ DeleteMessagesAfterMonths: -6 }, false},
+        {RetentionPolicy{ RedactAuditAfterMonths: -1 }, false},
+    }
+    for _, tt := range tests {
+        if got := tt.p.valid(); got != tt.want { t.Errorf("%+v.valid() = %v, want %v", tt.p, got, tt.want) }
+    }
+}
+
+func TestRetentionInterval(t *testing.T) {
+    tests := []struct{ env string; want time.Duration }{
+        {"", 24 * time.Hour},
+        {"6h", 6 * time.Hour},
+        {"0s", 24 * time.Hour},
+        {"daily", 24 * time.Hour},
+    }
+    for _, tt := range tests {
+        t.Setenv("RETENTION_INTERVAL", tt.env)
+        if got := retentionInterval(); got != tt.want { t.Errorf("RETENTION_INTERVAL=%q: got %v, want %v", tt.env, got, tt.want) }
+    }
+}
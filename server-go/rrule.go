@@ -0,0 +1,312 @@
+package main
+
+import (
+    "errors"
+    "log"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Recurrence works on dates, like the rest of the calendar: RRULEs are
+// expanded into occurrence start dates and each occurrence keeps the
+// master's length in nights. Supported: FREQ=DAILY/WEEKLY/MONTHLY/YEARLY
+// with INTERVAL, COUNT, UNTIL, BYDAY (with ordinals for MONTHLY/YEARLY),
+// BYMONTHDAY, BYMONTH and WKST.
+
+var errUnsupportedRRule = errors.New("unsupported_rrule")
+
+type weekdayNum struct {
+    N int // 0 for every such weekday, else 1st, 2nd, ... or -1 for the last
+    Day time.Weekday
+}
+
+type rrule struct {
+    Freq string
+    Interval int
+    Count int
+    Until time.Time
+    ByDay []weekdayNum
+    ByMonthDay []int
+    ByMonth []int
+    WeekStart time.Weekday
+}
+
+var icsWeekdays = map[string]time.Weekday{"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday, "TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday}
+
+func parseIntList(v string, min, max int) ([]int, error) {
+    var out []int
+    for _, p := range strings.Split(v, ",") {
+        n, err := strconv.Atoi(p)
+        if err != nil || n == 0 || n < min || n > max { return nil, errUnsupportedRRule }
+        out = append(out, n)
+    }
+    return out, nil
+}
+
+func parseRRule(s string) (rrule, error) {
+    rr := rrule{ Interval: 1, WeekStart: time.Monday }
+    for _, part := range strings.Split(strings.TrimSpace(s), ";") {
+        k, v, ok := strings.Cut(part, "=")
+        if !ok { return rr, errUnsupportedRRule }
+        v = strings.ToUpper(strings.TrimSpace(v))
+        var err error
+        switch strings.ToUpper(strings.TrimSpace(k)) {
+        case "FREQ":
+            if v != "DAILY" && v != "WEEKLY" && v != "MONTHLY" && v != "YEARLY" { return rr, errUnsupportedRRule }
+            rr.Freq = v
+        case "INTERVAL":
+            rr.Interval, err = strconv.Atoi(v)
+            if err != nil || rr.Interval < 1 { return rr, errUnsupportedRRule }
+        case "COUNT":
+            rr.Count, err = strconv.Atoi(v)
+            if err != nil || rr.Count < 1 { return rr, errUnsupportedRRule }
+        case "UNTIL":
+            t, allDay, err := parseICSTime(icsProp{ Value: v, Params: map[string]string{} })
+            if err != nil { return rr, errUnsupportedRRule }
+            if allDay { rr.Until = t } else { rr.Until = dateIn(t) }
+        case "BYDAY":
+            for _, d := range strings.Split(v, ",") {
+                if len(d) < 2 { return rr, errUnsupportedRRule }
+                wd, ok := icsWeekdays[d[len(d)-2:]]
+                if !ok { return rr, errUnsupportedRRule }
+                n := 0
+                if d[:len(d)-2] != "" {
+                    n, err = strconv.Atoi(d[:len(d)-2])
+                    if err != nil || n == 0 || n < -53 || n > 53 { return rr, errUnsupportedRRule }
+                }
+                rr.ByDay = append(rr.ByDay, weekdayNum{ N: n, Day: wd })
+            }
+        case "BYMONTHDAY":
+            if rr.ByMonthDay, err = parseIntList(v, -31, 31); err != nil { return rr, err }
+        case "BYMONTH":
+            if rr.ByMonth, err = parseIntList(v, 1, 12); err != nil { return rr, err }
+        case "WKST":
+            wd, ok := icsWeekdays[v]
+            if !ok { return rr, errUnsupportedRRule }
+            rr.WeekStart = wd
+        default:
+            return rr, errUnsupportedRRule
+        }
+    }
+    if rr.Freq == "" || (rr.Count > 0 && !rr.Until.IsZero()) { return rr, errUnsupportedRRule }
+    return rr, nil
+}
+
+func containsInt(xs []int, x int) bool {
+    for _, v := range xs { if v == x { return true } }
+    return false
+}
+
+// periodStart is the start of the DAILY/WEEKLY/MONTHLY/YEARLY period holding d.
+func (rr rrule) periodStart(d time.Time) time.Time {
+    switch rr.Freq {
+    case "WEEKLY": return d.AddDate(0, 0, -((int(d.Weekday()) - int(rr.WeekStart) + 7) % 7))
+    case "MONTHLY": return dateOnly(d.Year(), d.Month(), 1)
+    case "YEARLY": return dateOnly(d.Year(), time.January, 1)
+    }
+    return d
+}
+
+func (rr rrule) nextPeriod(p time.Time) time.Time {
+    switch rr.Freq {
+    case "WEEKLY": return p.AddDate(0, 0, 7*rr.Interval)
+    case "MONTHLY": return p.AddDate(0, rr.Interval, 0)
+    case "YEARLY": return p.AddDate(rr.Interval, 0, 0)
+    }
+    return p.AddDate(0, 0, rr.Interval)
+}
+
+func daysBetween(from, to time.Time) []time.Time {
+    var out []time.Time
+    for d := from; d.Before(to); d = d.AddDate(0, 0, 1) { out = append(out, d) }
+    return out
+}
+
+// filterByDay keeps days matching BYDAY; ordinals count within days.
+func filterByDay(days []time.Time, byDay []weekdayNum) []time.Time {
+    keep := map[time.Time]bool{}
+    for _, wd := range byDay {
+        var same []time.Time
+        for _, d := range days { if d.Weekday() == wd.Day { same = append(same, d) } }
+        switch {
+        case wd.N == 0: for _, d := range same { keep[d] = true }
+        case wd.N > 0 && wd.N <= len(same): keep[same[wd.N-1]] = true
+        case wd.N < 0 && -wd.N <= len(same): keep[same[len(same)+wd.N]] = true
+        }
+    }
+    var out []time.Time
+    for _, d := range days { if keep[d] { out = append(out, d) } }
+    return out
+}
+
+func filterByMonthDay(days []time.Time, byMonthDay []int) []time.Time {
+    var out []time.Time
+    for _, d := range days {
+        last := dateOnly(d.Year(), d.Month()+1, 1).AddDate(0, 0, -1).Day()
+        if containsInt(byMonthDay, d.Day()) || containsInt(byMonthDay, d.Day()-last-1) { out = append(out, d) }
+    }
+    return out
+}
+
+// monthCandidates picks the days of one month a MONTHLY or YEARLY rule hits.
+func (rr rrule) monthCandidates(y int, m time.Month, dtstart time.Time) []time.Time {
+    days := daysBetween(dateOnly(y, m, 1), dateOnly(y, m+1, 1))
+    if len(rr.ByMonthDay) > 0 { days = filterByMonthDay(days, rr.ByMonthDay) }
+    if len(rr.ByDay) > 0 { days = filterByDay(days, rr.ByDay) }
+    if len(rr.ByMonthDay) == 0 && len(rr.ByDay) == 0 {
+        days = filterByMonthDay(days, []int{dtstart.Day()})
+    }
+    return days
+}
+
+// candidates returns the occurrence dates within period p, in order.
+func (rr rrule) candidates(p, dtstart time.Time) []time.Time {
+    var days []time.Time
+    switch rr.Freq {
+    case "DAILY":
+        days = []time.Time{p}
+        if len(rr.ByMonthDay) > 0 { days = filterByMonthDay(days, rr.ByMonthDay) }
+        if len(rr.ByDay) > 0 {
+            var anyDay []weekdayNum
+            for _, wd := range rr.ByDay { anyDay = append(anyDay, weekdayNum{ Day: wd.Day }) }
+            days = filterByDay(days, anyDay)
+        }
+    case "WEEKLY":
+        days = daysBetween(p, p.AddDate(0, 0, 7))
+        byDay := rr.ByDay
+        if len(byDay) == 0 { byDay = []weekdayNum{{ Day: dtstart.Weekday() }} }
+        var anyDay []weekdayNum
+        for _, wd := range byDay { anyDay = append(anyDay, weekdayNum{ Day: wd.Day }) }
+        days = filterByDay(days, anyDay)
+    case "MONTHLY":
+        days = rr.monthCandidates(p.Year(), p.Month(), dtstart)
+    case "YEARLY":
+        switch {
+        case len(rr.ByMonth) > 0:
+            months := append([]int(nil), rr.ByMonth...)
+            sort.Ints(months)
+            for _, m := range months { days = append(days, rr.monthCandidates(p.Year(), time.Month(m), dtstart)...) }
+        case len(rr.ByMonthDay) > 0:
+            for m := time.January; m <= time.December; m++ { days = append(days, rr.monthCandidates(p.Year(), m, dtstart)...) }
+        case len(rr.ByDay) > 0:
+            days = filterByDay(daysBetween(p, p.AddDate(1, 0, 0)), rr.ByDay)
+        default:
+            days = rr.monthCandidates(p.Year(), dtstart.Month(), dtstart)
+        }
+    }
+    if len(rr.ByMonth) > 0 && rr.Freq != "YEARLY" {
+        var out []time.Time
+        for _, d := range days { if containsInt(rr.ByMonth, int(d.Month())) { out = append(out, d) } }
+        days = out
+    }
+    return days
+}
+
+// maxRRulePeriods bounds expansion of rules that never match.
+const maxRRulePeriods = 20000
+
+// each calls fn with every occurrence start before limit, in order, starting
+// with dtstart itself, until fn returns false or COUNT/UNTIL end the rule.
+func (rr rrule) each(dtstart, limit time.Time, fn func(time.Time) bool) {
+    if !dtstart.Before(limit) || (!rr.Until.IsZero() && dtstart.After(rr.Until)) { return }
+    if !fn(dtstart) { return }
+    count := 1
+    p := rr.periodStart(dtstart)
+    for i := 0; i < maxRRulePeriods; i++ {
+        for _, d := range rr.candidates(p, dtstart) {
+            if !d.After(dtstart) { continue }
+            if rr.Count > 0 && count >= rr.Count { return }
+            if (!rr.Until.IsZero() && d.After(rr.Until)) || !d.Before(limit) { return }
+            count++
+            if !fn(d) { return }
+        }
+        p = rr.nextPeriod(p)
+    }
+}
+
+// occurrences returns the instances of e overlapping [from, to). A
+// non-recurring event is its own only instance. An RRULE this code can't
+// expand is logged and the event held from its start to the end of the
+// requested window, since freeing nights it may recur on could double-book.
+func occurrences(e icsEvent, from, to time.Time) []icsEvent {
+    overlaps := func(o icsEvent) bool { return o.End.After(from) && o.Start.Before(to) }
+    if e.RRule == "" {
+        if overlaps(e) { return []icsEvent{e} }
+        return nil
+    }
+    rr, err := parseRRule(e.RRule)
+    if err != nil {
+        log.Printf("RRULE não suportada em %s (%q): ocupado de %s até %s", e.UID, e.RRule, e.Start.Format(dateLayout), to.Format(dateLayout))
+        o := e
+        o.RRule, o.ExDates = "", nil
+        if to.After(o.End) { o.End = to }
+        if overlaps(o) { return []icsEvent{o} }
+        return nil
+    }
+    nights := int(e.End.Sub(e.Start).Hours()/24 + 0.5)
+    excluded := map[time.Time]bool{}
+    for _, d := range e.ExDates { excluded[d] = true }
+    var out []icsEvent
+    rr.each(e.Start, to, func(d time.Time) bool {
+        if excluded[d] { return true }
+        o := e
+        o.Start, o.End, o.RRule, o.ExDates, o.RecurrenceID = d, d.AddDate(0, 0, nights), "", nil, d
+        if overlaps(o) { out = append(out, o) }
+        return true
+    })
+    return out
+}
+
+// expandEvents replaces recurring events with their instances overlapping
+// [from, to). Instances overridden by a RECURRENCE-ID event of the same UID
+// are left to the override, and CANCELLED overrides remove the instance.
+func expandEvents(events []icsEvent, from, to time.Time) []icsEvent {
+    overridden := map[string]map[time.Time]bool{}
+    for _, e := range events {
+        if e.RecurrenceID.IsZero() { continue }
+        if overridden[e.UID] == nil { overridden[e.UID] = map[time.Time]bool{} }
+        overridden[e.UID][e.RecurrenceID] = true
+    }
+    var out []icsEvent
+    for _, e := range events {
+        switch {
+        case !e.RecurrenceID.IsZero():
+            if e.Status != "CANCELLED" { out = append(out, e) }
+        case e.RRule == "":
+            out = append(out, e)
+        default:
+            for _, o := range occurrences(e, from, to) {
+                if !overridden[e.UID][o.RecurrenceID] { out = append(out, o) }
+            }
+        }
+    }
+    return out
+}
+
+// instanceUID gives each expanded instance its own UID, for consumers that
+// get the instances as separate events.
+func instanceUID(e icsEvent) string {
+    if e.RecurrenceID.IsZero() { return e.UID }
+    return e.UID + "-" + e.RecurrenceID.Format("20060102")
+}
+
+// expansionWindow is how far recurring events are expanded for consumers
+// that can't handle RRULE: a year back and two ahead.
+func expansionWindow() (time.Time, time.Time) {
+    today := dateIn(time.Now())
+    return today.AddDate(-1, 0, 0), today.AddDate(2, 0, 0)
+}
+
+// expandForFeed expands recurrences within expansionWindow and gives each
+// instance its own UID.
+func expandForFeed(events []icsEvent) []icsEvent {
+    from, to := expansionWindow()
+    out := expandEvents(events, from, to)
+    for i := range out {
+        out[i].UID = instanceUID(out[i])
+        out[i].RecurrenceID = time.Time{}
+    }
+    return out
+}
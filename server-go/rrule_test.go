@@ -0,0 +1,92 @@
+package main
+
+import (
+    "testing"
+    "time"
+)
+
+func TestExpandEvents(t *testing.T) {
+    d := func(s string) time.Time { return mustDate(t, s) }
+    series := func(start, end, rule string, exdates ...string) icsEvent {
+        e := icsEvent{ UID: "s", Start: d(start), End: d(end), RRule: rule }
+        for _, x := range exdates { e.ExDates = append(e.ExDates, d(x)) }
+        return e
+    }
+    tests := []struct {
+        name string
+        events []icsEvent
+        from, to string
+        want []string
+    }{
+        {"weekly by day", []icsEvent{series("2026-01-05", "2026-01-06", "FREQ=WEEKLY;BYDAY=MO,FR;COUNT=4")}, "2026-01-01", "2026-03-01",
+            []string{"2026-01-05/2026-01-06", "2026-01-09/2026-01-10", "2026-01-12/2026-01-13", "2026-01-16/2026-01-17"}},
+        {"weekly every other week", []icsEvent{series("2026-01-05", "2026-01-07", "FREQ=WEEKLY;INTERVAL=2")}, "2026-01-01", "2026-02-01",
+            []string{"2026-01-05/2026-01-07", "2026-01-19/2026-01-21"}},
+        {"yearly spanning new year", []icsEvent{series("2025-12-30", "2026-01-02", "FREQ=YEARLY;COUNT=3")}, "2026-01-01", "2028-01-01",
+            []string{"2025-12-30/2026-01-02", "2026-12-30/2027-01-02", "2027-12-30/2028-01-02"}},
+        {"yearly by month and day", []icsEvent{series("2026-02-14", "2026-02-16", "FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=14")}, "2026-01-01", "2028-01-01",
+            []string{"2026-02-14/2026-02-16", "2027-02-14/2027-02-16"}},
+        {"monthly last friday", []icsEvent{series("2026-01-30", "2026-02-01", "FREQ=MONTHLY;BYDAY=-1FR;COUNT=3")}, "2026-01-01", "2027-01-01",
+            []string{"2026-01-30/2026-02-01", "2026-02-27/2026-03-01", "2026-03-27/2026-03-29"}},
+        {"count", []icsEvent{series("2026-01-05", "2026-01-06", "FREQ=DAILY;COUNT=2")}, "2026-01-01", "2026-02-01",
+            []string{"2026-01-05/2026-01-06", "2026-01-06/2026-01-07"}},
+        {"until date", []icsEvent{series("2026-01-05", "2026-01-06", "FREQ=DAILY;UNTIL=20260107")}, "2026-01-01", "2026-02-01",
+            []string{"2026-01-05/2026-01-06", "2026-01-06/2026-01-07", "2026-01-07/2026-01-08"}},
+        {"until instant read at the property", []icsEvent{series("2026-01-05", "2026-01-06", "FREQ=DAILY;UNTIL=20260107T020000Z")}, "2026-01-01", "2026-02-01",
+            []string{"2026-01-05/2026-01-06", "2026-01-06/2026-01-07"}},
+        {"clipped to window", []icsEvent{series("2026-01-05", "2026-01-06", "FREQ=WEEKLY")}, "2026-01-10", "2026-01-20",
+            []string{"2026-01-12/2026-01-13", "2026-01-19/2026-01-20"}},
+        {"exdate keeps count", []icsEvent{series("2026-01-05", "2026-01-06", "FREQ=WEEKLY;COUNT=4", "2026-01-12")}, "2026-01-01", "2026-03-01",
+            []string{"2026-01-05/2026-01-06", "2026-01-19/2026-01-20", "2026-01-26/2026-01-27"}},
+        {"recurrence-id overrides", []icsEvent{
+            series("2026-01-05", "2026-01-06", "FREQ=WEEKLY;COUNT=3"),
+            { UID: "s", RecurrenceID: d("2026-01-12"), Start: d("2026-01-13"), End: d("2026-01-15") },
+            { UID: "s", RecurrenceID: d("2026-01-19"), Status: "CANCELLED", Start: d("2026-01-19"), End: d("2026-01-20") },
+            { UID: "other", RecurrenceID: d("2026-01-05"), Status: "CANCELLED", Start: d("2026-01-05"), End: d("2026-01-06") },
+        }, "2026-01-01", "2026-02-01",
+            []string{"2026-01-05/2026-01-06", "2026-01-13/2026-01-15"}},
+        {"plain event", []icsEvent{{ UID: "p", Start: d("2026-01-05"), End: d("2026-01-08") }}, "2026-01-01", "2026-02-01",
+            []string{"2026-01-05/2026-01-08"}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got := formatSpans(expandEvents(tt.events, d(tt.from), d(tt.to)))
+            if !sameSpans(got, tt.want) { t.Errorf("expandEvents = %v, want %v", got, tt.want) }
+        })
+    }
+}
+
+func TestUnsupportedRRuleHoldsToWindowEnd(t *testing.T) {
+    start := dateIn(time.Now()).AddDate(0, 1, 0)
+    e := icsEvent{ UID: "u", Start: start, End: start.AddDate(0, 0, 1), RRule: "FREQ=HOURLY" }
+    for _, to := range []time.Time{start.AddDate(0, 2, 0), start.AddDate(3, 0, 0)} {
+        got := occurrences(e, start.AddDate(0, -1, 0), to)
+        if len(got) != 1 || !got[0].Start.Equal(start) || !got[0].End.Equal(to) || got[0].RRule != "" {
+            t.Errorf("occurrences(..., %v) = %+v, want one event from %v to %v", to, got, start, to)
+        }
+    }
+    if got := occurrences(e, start.AddDate(0, -2, 0), start.AddDate(0, -1, 0)); len(got) != 0 { t.Errorf("window before the event: %+v", got) }
+}
+
+func TestParseRRule(t *testing.T) {
+    tests := []struct {
+        rule string
+        ok bool
+    }{
+        {"FREQ=WEEKLY;BYDAY=MO,FR", true},
+        {"freq=monthly;byday=-1fr", true},
+        {"FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=14;INTERVAL=2", true},
+        {"FREQ=DAILY;UNTIL=20260107T020000Z", true},
+        {"FREQ=WEEKLY;WKST=SU;COUNT=10", true},
+        {"FREQ=HOURLY", false},
+        {"FREQ=DAILY;COUNT=2;UNTIL=20260107", false},
+        {"FREQ=DAILY;BYSETPOS=1", false},
+        {"FREQ=WEEKLY;BYDAY=XX", false},
+        {"FREQ=MONTHLY;BYMONTHDAY=0", false},
+        {"INTERVAL=2", false},
+        {"FREQ=DAILY;INTERVAL=0", false},
+    }
+    for _, tt := range tests {
+        if _, err := parseRRule(tt.rule); (err == nil) != tt.ok { t.Errorf("parseRRule(%q) err = %v, want ok=%v", tt.rule, err, tt.ok) }
+    }
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+    "context"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net"
+    "net/http"
+    "os"
+    "strings"
+    "time"
+    "github.com/golang-jwt/jwt/v5"
+    "github.com/gorilla/mux"
+    "github.com/jackc/pgx/v5"
+    "golang.org/x/crypto/bcrypt"
+)
+
+const refreshTokenTTL = 30 * 24 * time.Hour
+
+var errSessionInvalid = errors.New("invalid_refresh_token")
+
+func accessTokenTTL() time.Duration {
+    if d, err := time.ParseDuration(os.Getenv("ACCESS_TOKEN_TTL")); err == nil && d > 0 { return d }
+    return 15 * time.Minute
+}
+
+func randomToken(n int) string {
+    b := make([]byte, n)
+    _, _ = rand.Read(b)
+    return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func hashToken(t string) string {
+    sum := sha256.Sum256([]byte(t))
+    return hex.EncodeToString(sum[:])
+}
+
+// clientIP only honours X-Forwarded-For behind a trusted proxy (TRUST_PROXY=1),
+// otherwise clients could spoof it to dodge rate limits.
+func clientIP(r *http.Request) string {
+    if os.Getenv("TRUST_PROXY") == "1" {
+        if f := r.Header.Get("X-Forwarded-For"); f != "" { return strings.TrimSpace(strings.Split(f, ",")[0]) }
+    }
+    host, _, err := net.SplitHostPort(r.RemoteAddr)
+    if err != nil { return r.RemoteAddr }
+    return host
+}
+
+// signAccessToken issues a short-lived JWT bound to a server-side session.
+func (s *Server) signAccessToken(email string, isOwner bool, sessionID string) (string, error) {
+    now := time.Now()
+    return s.jwtKeys.Sign(jwt.MapClaims{"email": email, "is_owner": isOwner, "sid": sessionID, "jti": randomToken(16), "iat": now.Unix(), "exp": now.Add(accessTokenTTL()).Unix()})
+}
+
+// issueSession creates a session and returns the token pair sent to clients.
+// Refresh tokens have the form "<session id>.<secret>"; only the hash is stored.
+func (s *Server) issueSession(ctx context.Context, r *http.Request, email string, isOwner bool) (map[string]any, error) {
+    secret := randomToken(32)
+    var sid string
+    if err := s.pool.QueryRow(ctx, "INSERT INTO sessions (user_email, refresh_hash, expires_at, user_agent, ip) VALUES ($1,$2,$3,$4,$5) RETURNING id::text", email, hashToken(secret), time.Now().Add(refreshTokenTTL), r.UserAgent(), clientIP(r)).Scan(&sid); err != nil { return nil, err }
+    access, err := s.signAccessToken(email, isOwner, sid)
+    if err != nil { return nil, err }
+    return map[string]any{"token": access, "refresh_token": sid + "." + secret, "expires_in": int(accessTokenTTL().Seconds())}, nil
+}
+
+// parseAccessToken validates a JWT and rejects tokens whose id or session has been revoked.
+func (s *Server) parseAccessToken(ctx context.Context, tokenStr string) (jwt.MapClaims, error) {
+    claims, err := s.jwtKeys.Parse(tokenStr)
+    if err != nil { return nil, err }
+    jti, _ := claims["jti"].(string)
+    sid, _ := claims["sid"].(string)
+    if jti == "" || sid == "" { return nil, errors.New("invalid_token") }
+    var revoked bool
+    err = s.pool.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM revoked_tokens WHERE jti=$1) OR NOT EXISTS(SELECT 1 FROM sessions WHERE id::text=$2 AND revoked_at IS NULL)", jti, sid).Scan(&revoked)
+    if err != nil { return nil, err }
+    if revoked { return nil, errors.New("token_revoked") }
+    return claims, nil
+}
+
+func (s *Server) revokeAccessToken(ctx context.Context, claims jwt.MapClaims) {
+    jti, _ := claims["jti"].(string)
+    exp, _ := claims["exp"].(float64)
+    if jti == "" { return }
+    _, _ = s.pool.Exec(ctx, "INSERT INTO revoked_tokens (jti, expires_at) VALUES ($1, to_timestamp($2)) ON CONFLICT DO NOTHING", jti, exp)
+    _, _ = s.pool.Exec(ctx, "DELETE FROM revoked_tokens WHERE expires_at < now()")
+}
+
+// revokeUserSessions ends every session of a user except keepSID.
+func (s *Server) revokeUserSessions(ctx context.Context, email, keepSID string) error {
+    _, err := s.pool.Exec(ctx, "UPDATE sessions SET revoked_at=now() WHERE user_email=$1 AND revoked_at IS NULL AND id::text <> $2", email, keepSID)
+    return err
+}
+
+// rotateRefreshToken swaps a refresh token for a new pair. Presenting an
+// already-rotated token revokes the session, since it was probably stolen.
+func (s *Server) rotateRefreshToken(ctx context.Context, refresh string) (map[string]any, error) {
+    sid, secret, ok := strings.Cut(refresh, ".")
+    if !ok || sid == "" || secret == "" { return nil, errSessionInvalid }
+    tx, err := s.pool.Begin(ctx)
+    if err != nil { return nil, err }
+    defer tx.Rollback(ctx)
+    var email, current, previous string; var revoked bool; var expires time.Time
+    err = tx.QueryRow(ctx, "SELECT user_email, refresh_hash, COALESCE(previous_refresh_hash,''), revoked_at IS NOT NULL, expires_at FROM sessions WHERE id::text=$1 FOR UPDATE", sid).Scan(&email, &current, &previous, &revoked, &expires)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) { return nil, errSessionInvalid }
+        return nil, err
+    }
+    h := hashToken(secret)
+    if revoked || time.Now().After(expires) { return nil, errSessionInvalid }
+    if h == previous {
+        if _, err := tx.Exec(ctx, "UPDATE sessions SET revoked_at=now() WHERE id::text=$1", sid); err != nil { return nil, err }
+        if err := tx.Commit(ctx); err != nil { return nil, err }
+        return nil, errSessionInvalid
+    }
+    if h != current { return nil, errSessionInvalid }
+    var isOwner bool
+    if err := tx.QueryRow(ctx, "SELECT COALESCE(is_owner,false) FROM users WHERE email=$1", email).Scan(&isOwner); err != nil { return nil, err }
+    next := randomToken(32)
+    if _, err := tx.Exec(ctx, "UPDATE sessions SET previous_refresh_hash=refresh_hash, refresh_hash=$2, last_used_at=now(), expires_at=$3 WHERE id::text=$1", sid, hashToken(next), time.Now().Add(refreshTokenTTL)); err != nil { return nil, err }
+    if err := tx.Commit(ctx); err != nil { return nil, err }
+    access, err := s.signAccessToken(email, isOwner, sid)
+    if err != nil { return nil, err }
+    return map[string]any{"token": access, "refresh_token": sid + "." + next, "expires_in": int(accessTokenTTL().Seconds())}, nil
+}
+
+func (s *Server) handleRefresh(w http.ResponseWriter, r *http.Request) {
+    var body struct{ RefreshToken string `json:"refresh_token"` }
+    _ = json.NewDecoder(r.Body).Decode(&body)
+    out, err := s.rotateRefreshToken(r.Context(), body.RefreshToken)
+    if err != nil {
+        if errors.Is(err, errSessionInvalid) { jsonResp(w, 401, map[string]string{"error":"invalid_refresh_token"}); return }
+        jsonResp(w, 500, map[string]string{"error": err.Error()}); return
+    }
+    jsonResp(w, 200, out)
+}
+
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+    c := getClaims(r)
+    if _, err := s.pool.Exec(r.Context(), "UPDATE sessions SET revoked_at=now() WHERE id::text=$1 AND revoked_at IS NULL", c["sid"]); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    s.revokeAccessToken(r.Context(), c)
+    s.audit(r, auditEntry{ Action: "auth.logout", TargetType: "session", TargetID: fmt.Sprint(c["sid"]) })
+    jsonResp(w, 200, map[string]bool{"success": true})
+}
+
+func (s *Server) handleLogoutAll(w http.ResponseWriter, r *http.Request) {
+    c := getClaims(r)
+    if err := s.revokeUserSessions(r.Context(), c["email"].(string), ""); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    s.revokeAccessToken(r.Context(), c)
+    s.audit(r, auditEntry{ Action: "auth.logout_all", TargetType: "user", TargetID: fmt.Sprint(c["email"]) })
+    jsonResp(w, 200, map[string]bool{"success": true})
+}
+
+func (s *Server) handleListSessions(w http.ResponseWriter, r *http.Request) {
+    c := getClaims(r)
+    rows, err := s.pool.Query(r.Context(), "SELECT id::text, COALESCE(user_agent,''), COALESCE(ip,''), created_at, COALESCE(last_used_at, created_at), expires_at FROM sessions WHERE user_email=$1 AND revoked_at IS NULL AND expires_at > now() ORDER BY created_at DESC", c["email"])
+    if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    type rec struct{ ID string `json:"id"`; UserAgent string `json:"user_agent"`; IP string `json:"ip"`; CreatedAt time.Time `json:"created_at"`; LastUsedAt time.Time `json:"last_used_at"`; ExpiresAt time.Time `json:"expires_at"`; Current bool `json:"current"` }
+    var out []rec
+    for rows.Next() { var a rec; if err := rows.Scan(&a.ID,&a.UserAgent,&a.IP,&a.CreatedAt,&a.LastUsedAt,&a.ExpiresAt); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return } ; a.Current = a.ID == c["sid"]; out = append(out,a) }
+    if rows.Err() != nil { jsonResp(w, 500, map[string]string{"error": rows.Err().Error()}); return }
+    jsonResp(w, 200, map[string]any{"data": out})
+}
+
+func (s *Server) handleRevokeSession(w http.ResponseWriter, r *http.Request) {
+    c := getClaims(r)
+    tag, err := s.pool.Exec(r.Context(), "UPDATE sessions SET revoked_at=now() WHERE id::text=$1 AND user_email=$2 AND revoked_at IS NULL", mux.Vars(r)["id"], c["email"])
+    if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    if tag.RowsAffected() == 0 { jsonResp(w, 404, map[string]string{"error":"not_found"}); return }
+    s.audit(r, auditEntry{ Action: "session.revoke", TargetType: "session", TargetID: mux.Vars(r)["id"] })
+    jsonResp(w, 200, map[string]bool{"success": true})
+}
+
+// handleChangePassword updates the password and logs out every other session.
+func (s *Server) handleChangePassword(w http.ResponseWriter, r *http.Request) {
+    c := getClaims(r)
+    var body struct{ CurrentPassword, NewPassword string }
+    _ = json.NewDecoder(r.Body).Decode(&body)
+    if body.NewPassword == "" { jsonResp(w, 400, map[string]string{"error":"invalid_input"}); return }
+    var hash string
+    if err := s.pool.QueryRow(r.Context(), "SELECT password_hash FROM users WHERE email=$1", c["email"]).Scan(&hash); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    if bcrypt.CompareHashAndPassword([]byte(hash), []byte(body.CurrentPassword)) != nil { jsonResp(w, 401, map[string]string{"error":"invalid_credentials"}); return }
+    newHash, _ := bcrypt.GenerateFromPassword([]byte(body.NewPassword), 10)
+    if _, err := s.pool.Exec(r.Context(), "UPDATE users SET password_hash=$2 WHERE email=$1", c["email"], string(newHash)); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    sid, _ := c["sid"].(string)
+    if err := s.revokeUserSessions(r.Context(), c["email"].(string), sid); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    s.audit(r, auditEntry{ Action: "auth.password_change", TargetType: "user", TargetID: fmt.Sprint(c["email"]) })
+    jsonResp(w, 200, map[string]bool{"success": true})
+}
+
+const reauthWindow = 5 * time.Minute
+
+// requireReauth asks for the password again before a sensitive change, under
+// the same lockout as login. Accounts without a password (OIDC) must instead
+// be using a session started in the last few minutes.
+func (s *Server) requireReauth(w http.ResponseWriter, r *http.Request, email, sid, password string) bool {
+    lockKey := "login:" + strings.ToLower(strings.TrimSpace(email))
+    if d, err := s.limiter.LockedFor(r.Context(), lockKey); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return false } else if d > 0 { writeRateLimited(w, d); return false }
+    var hash string
+    if err := s.pool.QueryRow(r.Context(), "SELECT password_hash FROM users WHERE email=$1", email).Scan(&hash); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return false }
+    if hash == "!" {
+        var fresh bool
+        if err := s.pool.QueryRow(r.Context(), "SELECT EXISTS(SELECT 1 FROM sessions WHERE id::text=$1 AND user_email=$2 AND revoked_at IS NULL AND created_at > now() - $3 * interval '1 second')", sid, email, int64(reauthWindow/time.Second)).Scan(&fresh); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return false }
+        if !fresh { jsonResp(w, 401, map[string]string{"error":"reauth_required"}); return false }
+        return true
+    }
+    if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+        if d, err := s.limiter.RecordFailure(r.Context(), lockKey); err == nil && d > 0 { writeRateLimited(w, d); return false }
+        jsonResp(w, 401, map[string]string{"error":"invalid_credentials"}); return false
+    }
+    _ = s.limiter.ResetFailures(r.Context(), lockKey)
+    return true
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+    "testing"
+    "time"
+)
+
+func TestHashToken(t *testing.T) {
+    if got := hashToken("abc"); got != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" { t.Errorf("hashToken(abc) = %s", got) }
+}
+
+func TestRandomToken(t *testing.T) {
+    a, b := randomToken(16), randomToken(16)
+    if len(a) != 22 || a == b { t.Errorf("randomToken(16) = %q, %q", a, b) }
+}
+
+func TestAccessTokenTTL(t *testing.T) {
+    tests := []struct{ env string; want time.Duration }{
+        {"", 15 * time.Minute},
+        {"5m", 5 * time.Minute},
+        {"-1m", 15 * time.Minute},
+        {"soon", 15 * time.Minute},
+    }
+    for _, tt := range tests {
+        t.Setenv("ACCESS_TOKEN_TTL", tt.env)
+        if got := accessTokenTTL(); got != tt.want { t.Errorf("ACCESS_TOKEN_TTL=%q: got %v, want %v", tt.env, got, tt.want) }
+    }
+}
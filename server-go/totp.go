@@ -0,0 +1,338 @@
+package main
+
+import (
+    "context"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha1"
+    "crypto/subtle"
+    "encoding/base32"
+    "encoding/binary"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "net/url"
+    "os"
+    "strings"
+    "time"
+    "github.com/jackc/pgx/v5"
+)
+
+const (
+    totpPeriod = 30
+    totpDigits = 6
+    mfaChallengeTTL = 5 * time.Minute
+    mfaChallengeMaxAttempts = 5
+    recoveryCodeCount = 10
+)
+
+var errMFAChallenge = errors.New("invalid_challenge")
+
+func totpIssuer() string {
+    if v := os.Getenv("TOTP_ISSUER"); v != "" { return v }
+    return "Ocean Haven"
+}
+
+func newTOTPSecret() string {
+    b := make([]byte, 20)
+    _, _ = rand.Read(b)
+    return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)
+}
+
+func totpURI(secret, email string) string {
+    issuer := totpIssuer()
+    v := url.Values{}
+    v.Set("secret", secret)
+    v.Set("issuer", issuer)
+    v.Set("algorithm", "SHA1")
+    v.Set("digits", fmt.Sprint(totpDigits))
+    v.Set("period", fmt.Sprint(totpPeriod))
+    return "otpauth://totp/" + url.PathEscape(issuer+":"+email) + "?" + v.Encode()
+}
+
+// totpCode computes the RFC 6238 code for a time step.
+func totpCode(secret string, step int64) (string, error) {
+    key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+    if err != nil { return "", err }
+    var msg [8]byte
+    binary.BigEndian.PutUint64(msg[:], uint64(step))
+    mac := hmac.New(sha1.New, key)
+    mac.Write(msg[:])
+    sum := mac.Sum(nil)
+    off := sum[len(sum)-1] & 0x0f
+    bin := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fffffff
+    return fmt.Sprintf("%0*d", totpDigits, bin%1000000), nil
+}
+
+// matchTOTP returns the matching time step, allowing one step of clock drift,
+// and refuses steps at or before lastStep so a code can't be replayed.
+func matchTOTP(secret, code string, now time.Time, lastStep int64) (int64, bool) {
+    code = strings.TrimSpace(code)
+    cur := now.Unix() / totpPeriod
+    for _, step := range []int64{cur - 1, cur, cur + 1} {
+        if step <= lastStep { continue }
+        want, err := totpCode(secret, step)
+        if err != nil { return 0, false }
+        if subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 { return step, true }
+    }
+    return 0, false
+}
+
+func newRecoveryCodes() []string {
+    const alphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+    out := make([]string, recoveryCodeCount)
+    for i := range out {
+        b := make([]byte, 10)
+        _, _ = rand.Read(b)
+        for j := range b { b[j] = alphabet[int(b[j])%len(alphabet)] }
+        out[i] = string(b[:5]) + "-" + string(b[5:])
+    }
+    return out
+}
+
+func (s *Server) ownerTwoFactorRequired(ctx context.Context) (bool, error) {
+    var v bool
+    err := s.pool.QueryRow(ctx, "SELECT COALESCE((value->>'require_owner_2fa')::boolean, false) FROM app_settings WHERE key='security'").Scan(&v)
+    if errors.Is(err, pgx.ErrNoRows) { return false, nil }
+    return v, err
+}
+
+func (s *Server) createMFAChallenge(ctx context.Context, email, purpose string) (string, error) {
+    var id string
+    err := s.pool.QueryRow(ctx, "INSERT INTO mfa_challenges (user_email, purpose, expires_at) VALUES ($1,$2,$3) RETURNING id::text", email, purpose, time.Now().Add(mfaChallengeTTL)).Scan(&id)
+    return id, err
+}
+
+// loginSecondFactor decides whether a password login needs another step. It
+// returns nil when a session can be issued right away.
+func (s *Server) loginSecondFactor(ctx context.Context, email string, isOwner bool) (map[string]any, error) {
+    var enabled bool
+    if err := s.pool.QueryRow(ctx, "SELECT totp_enabled FROM users WHERE email=$1", email).Scan(&enabled); err != nil { return nil, err }
+    if enabled {
+        id, err := s.createMFAChallenge(ctx, email, "login")
+        if err != nil { return nil, err }
+        return map[string]any{"mfa_required": true, "challenge": id}, nil
+    }
+    if !isOwner { return nil, nil }
+    required, err := s.ownerTwoFactorRequired(ctx)
+    if err != nil || !required { return nil, err }
+    id, err := s.createMFAChallenge(ctx, email, "enroll")
+    if err != nil { return nil, err }
+    return map[string]any{"mfa_enrollment_required": true, "challenge": id}, nil
+}
+
+// useMFAChallenge checks a challenge and counts the attempt against it.
+func (s *Server) useMFAChallenge(ctx context.Context, id, purpose string) (string, error) {
+    var email string
+    err := s.pool.QueryRow(ctx, "UPDATE mfa_challenges SET attempts=attempts+1 WHERE id::text=$1 AND purpose=$2 AND used_at IS NULL AND expires_at > now() AND attempts < $3 RETURNING user_email", id, purpose, mfaChallengeMaxAttempts).Scan(&email)
+    if errors.Is(err, pgx.ErrNoRows) { return "", errMFAChallenge }
+    return email, err
+}
+
+// checkSecondFactor accepts a current TOTP code or an unused recovery code.
+func (s *Server) checkSecondFactor(ctx context.Context, email, code string) (bool, error) {
+    var secret string; var lastStep int64
+    if err := s.pool.QueryRow(ctx, "SELECT COALESCE(totp_secret,''), COALESCE(totp_last_step,0) FROM users WHERE email=$1 AND totp_enabled", email).Scan(&secret, &lastStep); err != nil {
+        if errors.Is(err, pgx.ErrNoRows) { return false, nil }
+        return false, err
+    }
+    if step, ok := matchTOTP(secret, code, time.Now(), lastStep); ok {
+        // Conditional on the stored step so two logins racing with the same
+        // code can't both succeed.
+        tag, err := s.pool.Exec(ctx, "UPDATE users SET totp_last_step=$2 WHERE email=$1 AND COALESCE(totp_last_step,0) < $2", email, step)
+        if err != nil { return false, err }
+        return tag.RowsAffected() == 1, nil
+    }
+    tag, err := s.pool.Exec(ctx, "UPDATE totp_recovery_codes SET used_at=now() WHERE user_email=$1 AND code_hash=$2 AND used_at IS NULL", email, hashToken(strings.ToLower(strings.TrimSpace(code))))
+    if err != nil { return false, err }
+    return tag.RowsAffected() == 1, nil
+}
+
+// requireSecondFactor checks a code under a per-account lockout. Challenges
+// and sessions only bound attempts per request, so without it the code space
+// could be tried again through fresh ones.
+func (s *Server) requireSecondFactor(w http.ResponseWriter, r *http.Request, email, code string, failStatus int) bool {
+    lockKey := "2fa:" + strings.ToLower(email)
+    if d, err := s.limiter.LockedFor(r.Context(), lockKey); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return false } else if d > 0 { writeRateLimited(w, d); return false }
+    ok, err := s.checkSecondFactor(r.Context(), email, code)
+    if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return false }
+    if !ok {
+        s.audit(r, auditEntry{ Actor: email, Action: "auth.2fa_failed", TargetType: "user", TargetID: email })
+        if d, err := s.limiter.RecordFailure(r.Context(), lockKey); err == nil && d > 0 { writeRateLimited(w, d); return false }
+        jsonResp(w, failStatus, map[string]string{"error":"invalid_code"}); return false
+    }
+    _ = s.limiter.ResetFailures(r.Context(), lockKey)
+    return true
+}
+
+func (s *Server) replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, email string) ([]string, error) {
+    codes := newRecoveryCodes()
+    if _, err := tx.Exec(ctx, "DELETE FROM totp_recovery_codes WHERE user_email=$1", email); err != nil { return nil, err }
+    for _, c := range codes {
+        if _, err := tx.Exec(ctx, "INSERT INTO totp_recovery_codes (user_email, code_hash) VALUES ($1,$2)", email, hashToken(c)); err != nil { return nil, err }
+    }
+    return codes, nil
+}
+
+// twoFactorSubject identifies the user enrolling: either a signed-in user or an
+// owner holding an enrollment challenge from a login that requires 2FA. For a
+// signed-in user it also returns the session id.
+func (s *Server) twoFactorSubject(r *http.Request, challenge string) (string, string, bool, error) {
+    if challenge != "" {
+        var email string
+        err := s.pool.QueryRow(r.Context(), "SELECT user_email FROM mfa_challenges WHERE id::text=$1 AND purpose='enroll' AND used_at IS NULL AND expires_at > now()", challenge).Scan(&email)
+        if errors.Is(err, pgx.ErrNoRows) { return "", "", true, errMFAChallenge }
+        return email, "", true, err
+    }
+    hdr := r.Header.Get("Authorization")
+    if !strings.HasPrefix(hdr, "Bearer ") { return "", "", false, errMFAChallenge }
+    claims, err := s.parseAccessToken(r.Context(), strings.TrimPrefix(hdr, "Bearer "))
+    if err != nil { return "", "", false, errMFAChallenge }
+    email, _ := claims["email"].(string)
+    sid, _ := claims["sid"].(string)
+    return email, sid, false, nil
+}
+
+func (s *Server) handleTOTPEnroll(w http.ResponseWriter, r *http.Request) {
+    var body struct{ Challenge, Password string }
+    _ = json.NewDecoder(r.Body).Decode(&body)
+    email, sid, viaChallenge, err := s.twoFactorSubject(r, body.Challenge)
+    if err != nil {
+        if errors.Is(err, errMFAChallenge) { jsonResp(w, 401, map[string]string{"error":"unauthorized"}); return }
+        jsonResp(w, 500, map[string]string{"error": err.Error()}); return
+    }
+    // A challenge comes straight from a password login; a session could be a
+    // stolen token, and enrolling would lock its owner out.
+    if !viaChallenge && !s.requireReauth(w, r, email, sid, body.Password) { return }
+    secret := newTOTPSecret()
+    tag, err := s.pool.Exec(r.Context(), "UPDATE users SET totp_secret=$2, totp_last_step=0 WHERE email=$1 AND NOT totp_enabled", email, secret)
+    if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    if tag.RowsAffected() == 0 { jsonResp(w, 409, map[string]string{"error":"already_enabled"}); return }
+    s.audit(r, auditEntry{ Actor: email, Action: "2fa.enroll", TargetType: "user", TargetID: email })
+    jsonResp(w, 200, map[string]string{"secret": secret, "otpauth_uri": totpURI(secret, email)})
+}
+
+// handleTOTPConfirm turns 2FA on once the user proves the authenticator works.
+// When enrolling from a login challenge it also completes that login.
+func (s *Server) handleTOTPConfirm(w http.ResponseWriter, r *http.Request) {
+    var body struct{ Challenge, Code string }
+    _ = json.NewDecoder(r.Body).Decode(&body)
+    email, _, viaChallenge, err := s.twoFactorSubject(r, body.Challenge)
+    if err != nil {
+        if errors.Is(err, errMFAChallenge) { jsonResp(w, 401, map[string]string{"error":"unauthorized"}); return }
+        jsonResp(w, 500, map[string]string{"error": err.Error()}); return
+    }
+    if viaChallenge {
+        if _, err := s.useMFAChallenge(r.Context(), body.Challenge, "enroll"); err != nil { jsonResp(w, 401, map[string]string{"error":"unauthorized"}); return }
+    }
+    var secret string; var enabled, isOwner bool
+    if err := s.pool.QueryRow(r.Context(), "SELECT COALESCE(totp_secret,''), totp_enabled, COALESCE(is_owner,false) FROM users WHERE email=$1", email).Scan(&secret, &enabled, &isOwner); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    if enabled { jsonResp(w, 409, map[string]string{"error":"already_enabled"}); return }
+    if secret == "" { jsonResp(w, 400, map[string]string{"error":"not_enrolled"}); return }
+    step, ok := matchTOTP(secret, body.Code, time.Now(), 0)
+    if !ok { jsonResp(w, 400, map[string]string{"error":"invalid_code"}); return }
+    tx, err := s.pool.Begin(r.Context())
+    if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    defer tx.Rollback(r.Context())
+    tag, err := tx.Exec(r.Context(), "UPDATE users SET totp_enabled=TRUE, totp_last_step=$2 WHERE email=$1 AND NOT totp_enabled", email, step)
+    if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    if tag.RowsAffected() == 0 { jsonResp(w, 409, map[string]string{"error":"already_enabled"}); return }
+    codes, err := s.replaceRecoveryCodes(r.Context(), tx, email)
+    if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    if viaChallenge {
+        if _, err := tx.Exec(r.Context(), "UPDATE mfa_challenges SET used_at=now() WHERE id::text=$1", body.Challenge); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    }
+    if err := tx.Commit(r.Context()); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    s.audit(r, auditEntry{ Actor: email, Action: "2fa.enable", TargetType: "user", TargetID: email })
+    out := map[string]any{"recovery_codes": codes}
+    if viaChallenge {
+        sess, err := s.issueSession(r.Context(), r, email, isOwner)
+        if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+        for k, v := range sess { out[k] = v }
+    }
+    jsonResp(w, 200, out)
+}
+
+// handleTOTPLogin completes a login that returned mfa_required.
+func (s *Server) handleTOTPLogin(w http.ResponseWriter, r *http.Request) {
+    var body struct{ Challenge, Code string }
+    _ = json.NewDecoder(r.Body).Decode(&body)
+    email, err := s.useMFAChallenge(r.Context(), body.Challenge, "login")
+    if err != nil {
+        if errors.Is(err, errMFAChallenge) { jsonResp(w, 401, map[string]string{"error":"invalid_challenge"}); return }
+        jsonResp(w, 500, map[string]string{"error": err.Error()}); return
+    }
+    if !s.requireSecondFactor(w, r, email, body.Code, 401) { return }
+    if _, err := s.pool.Exec(r.Context(), "UPDATE mfa_challenges SET used_at=now() WHERE id::text=$1", body.Challenge); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    var isOwner bool
+    if err := s.pool.QueryRow(r.Context(), "SELECT COALESCE(is_owner,false) FROM users WHERE email=$1", email).Scan(&isOwner); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    out, err := s.issueSession(r.Context(), r, email, isOwner)
+    if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    s.audit(r, auditEntry{ Actor: email, Action: "auth.login", TargetType: "user", TargetID: email, After: map[string]bool{"mfa": true} })
+    jsonResp(w, 200, out)
+}
+
+func (s *Server) handleTOTPDisable(w http.ResponseWriter, r *http.Request) {
+    c := getClaims(r)
+    var body struct{ Code string }
+    _ = json.NewDecoder(r.Body).Decode(&body)
+    var isOwner bool
+    if err := s.pool.QueryRow(r.Context(), "SELECT COALESCE(is_owner,false) FROM users WHERE email=$1", c["email"]).Scan(&isOwner); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    if isOwner {
+        required, err := s.ownerTwoFactorRequired(r.Context())
+        if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+        if required { jsonResp(w, 403, map[string]string{"error":"2fa_required"}); return }
+    }
+    email, _ := c["email"].(string)
+    if !s.requireSecondFactor(w, r, email, body.Code, 400) { return }
+    if _, err := s.pool.Exec(r.Context(), "UPDATE users SET totp_enabled=FALSE, totp_secret=NULL, totp_last_step=0 WHERE email=$1", email); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    if _, err := s.pool.Exec(r.Context(), "DELETE FROM totp_recovery_codes WHERE user_email=$1", email); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    s.audit(r, auditEntry{ Action: "2fa.disable", TargetType: "user", TargetID: email })
+    jsonResp(w, 200, map[string]bool{"success": true})
+}
+
+func (s *Server) handleTOTPRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+    c := getClaims(r)
+    var body struct{ Code string }
+    _ = json.NewDecoder(r.Body).Decode(&body)
+    email, _ := c["email"].(string)
+    if !s.requireSecondFactor(w, r, email, body.Code, 400) { return }
+    tx, err := s.pool.Begin(r.Context())
+    if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    defer tx.Rollback(r.Context())
+    codes, err := s.replaceRecoveryCodes(r.Context(), tx, email)
+    if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    if err := tx.Commit(r.Context()); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    s.audit(r, auditEntry{ Action: "2fa.recovery_codes", TargetType: "user", TargetID: email })
+    jsonResp(w, 200, map[string]any{"recovery_codes": codes})
+}
+
+func (s *Server) handleGetSecuritySettings(w http.ResponseWriter, r *http.Request) {
+    if !s.requireOwner(w, r) { return }
+    required, err := s.ownerTwoFactorRequired(r.Context())
+    if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    jsonResp(w, 200, map[string]bool{"require_owner_2fa": required})
+}
+
+// handlePutSecuritySettings toggles mandatory 2FA for owners. Turning it on
+// logs out owners who haven't enrolled so they go through enrollment.
+func (s *Server) handlePutSecuritySettings(w http.ResponseWriter, r *http.Request) {
+    if !s.requireOwner(w, r) { return }
+    c := getClaims(r)
+    var body struct{ RequireOwner2FA bool `json:"require_owner_2fa"` }
+    _ = json.NewDecoder(r.Body).Decode(&body)
+    if body.RequireOwner2FA {
+        var enabled bool
+        if err := s.pool.QueryRow(r.Context(), "SELECT totp_enabled FROM users WHERE email=$1", c["email"]).Scan(&enabled); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+        if !enabled { jsonResp(w, 400, map[string]string{"error":"enroll_2fa_first"}); return }
+    }
+    before, err := s.ownerTwoFactorRequired(r.Context())
+    if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    if _, err := s.pool.Exec(r.Context(), "INSERT INTO app_settings (key, value) VALUES ('security', jsonb_build_object('require_owner_2fa', $1::boolean)) ON CONFLICT (key) DO UPDATE SET value = app_settings.value || EXCLUDED.value, updated_at=now()", body.RequireOwner2FA); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    if body.RequireOwner2FA {
+        if _, err := s.pool.Exec(r.Context(), "UPDATE sessions SET revoked_at=now() WHERE revoked_at IS NULL AND user_email IN (SELECT email FROM users WHERE is_owner AND NOT totp_enabled)"); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    }
+    s.audit(r, auditEntry{ Action: "settings.security", TargetType: "settings", TargetID: "security", Before: map[string]bool{"require_owner_2fa": before}, After: map[string]bool{"require_owner_2fa": body.RequireOwner2FA} })
+    jsonResp(w, 200, map[string]bool{"require_owner_2fa": body.RequireOwner2FA})
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+    "net/url"
+    "strings"
+    "testing"
+    "time"
+)
+
+// rfc6238Secret is the SHA1 key from RFC 6238 appendix B, "12345678901234567890".
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+    // The RFC lists 8-digit codes; ours are their last 6 digits.
+    tests := []struct{ unix int64; want string }{
+        {59, "287082"},
+        {1111111109, "081804"},
+        {1111111111, "050471"},
+        {1234567890, "005924"},
+        {2000000000, "279037"},
+        {20000000000, "353130"},
+    }
+    for _, tt := range tests {
+        got, err := totpCode(rfc6238Secret, tt.unix/totpPeriod)
+        if err != nil { t.Fatal(err) }
+        if got != tt.want { t.Errorf("T=%d: got %s, want %s", tt.unix, got, tt.want) }
+    }
+    if lower, _ := totpCode(strings.ToLower(rfc6238Secret), 1); lower == "" { t.Error("lowercase secret rejected") }
+    if _, err := totpCode("not base32!", 1); err == nil { t.Error("invalid secret accepted") }
+}
+
+func TestMatchTOTP(t *testing.T) {
+    now := time.Unix(1111111111, 0)
+    cur := now.Unix() / totpPeriod
+    code := func(step int64) string { c, _ := totpCode(rfc6238Secret, step); return c }
+    tests := []struct {
+        name, code string
+        lastStep int64
+        wantStep int64
+        wantOK bool
+    }{
+        {"current", code(cur), 0, cur, true},
+        {"previous step", code(cur - 1), 0, cur - 1, true},
+        {"next step", " " + code(cur + 1) + " ", 0, cur + 1, true},
+        {"too old", code(cur - 2), 0, 0, false},
+        {"replayed", code(cur), cur, 0, false},
+        {"replay of earlier step", code(cur - 1), cur - 1, 0, false},
+        {"wrong", "000000", 0, 0, false},
+    }
+    for _, tt := range tests {
+        step, ok := matchTOTP(rfc6238Secret, tt.code, now, tt.lastStep)
+        if ok != tt.wantOK || step != tt.wantStep { t.Errorf("%s: got (%d, %v), want (%d, %v)", tt.name, step, ok, tt.wantStep, tt.wantOK) }
+    }
+}
+
+func TestTOTPURI(t *testing.T) {
+    u, err := url.Parse(totpURI(rfc6238Secret, "owner@example.com"))
+    if err != nil { t.Fatal(err) }
+    q := u.Query()
+    if u.Scheme != "otpauth" || u.Host != "totp" || q.Get("secret") != rfc6238Secret || q.Get("digits") != "6" || q.Get("period") != "30" {
+        t.Errorf("unexpected URI %s", u)
+    }
+}
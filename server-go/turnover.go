@@ -0,0 +1,136 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "time"
+    "github.com/jackc/pgx/v5"
+)
+
+// TurnoverPolicy is the preparation time kept free around site bookings,
+// stored in app_settings key 'turnover'. With MinStayNights or MinGuests set,
+// only stays reaching either threshold get the buffer.
+type TurnoverPolicy struct {
+    BeforeNights int `json:"before_nights"`
+    AfterNights int `json:"after_nights"`
+    MinStayNights int `json:"min_stay_nights"`
+    MinGuests int `json:"min_guests"`
+}
+
+// preparationSummary names buffer events; like unavailableSummary it is what
+// channels see, so it isn't translated.
+const preparationSummary = "Preparation"
+
+func (s *Server) turnoverPolicy(ctx context.Context) (TurnoverPolicy, error) {
+    var t TurnoverPolicy
+    var raw []byte
+    err := s.pool.QueryRow(ctx, "SELECT value FROM app_settings WHERE key='turnover'").Scan(&raw)
+    if errors.Is(err, pgx.ErrNoRows) { return t, nil }
+    if err != nil { return t, err }
+    return t, json.Unmarshal(raw, &t)
+}
+
+// unconditional reports whether every stay gets the buffer.
+func (t TurnoverPolicy) unconditional() bool { return t.MinStayNights == 0 && t.MinGuests == 0 }
+
+func (t TurnoverPolicy) applies(nights, guests int) bool {
+    if t.unconditional() { return true }
+    return (t.MinStayNights > 0 && nights >= t.MinStayNights) || (t.MinGuests > 0 && guests >= t.MinGuests)
+}
+
+// preparationEvents returns the buffer nights before and after a booking as
+// events of their own, with the booking's status so requested stays only
+// hold their buffer tentatively.
+func preparationEvents(t TurnoverPolicy, booking icsEvent, guests int) []icsEvent {
+    nights := int(booking.End.Sub(booking.Start).Hours()/24 + 0.5)
+    if !t.applies(nights, guests) { return nil }
+    prep := icsEvent{ Summary: preparationSummary, Category: "Preparation", Status: booking.Status, Modified: booking.Modified, Sequence: booking.Sequence }
+    var out []icsEvent
+    if t.BeforeNights > 0 {
+        e := prep
+        e.UID, e.Start, e.End = booking.UID+"-prep-before", booking.Start.AddDate(0, 0, -t.BeforeNights), booking.Start
+        out = append(out, e)
+    }
+    if t.AfterNights > 0 {
+        e := prep
+        e.UID, e.Start, e.End = booking.UID+"-prep-after", booking.End, booking.End.AddDate(0, 0, t.AfterNights)
+        out = append(out, e)
+    }
+    return out
+}
+
+// isStay reports whether an expanded event is a stay, site or imported,
+// rather than a block or a buffer.
+func isStay(e icsEvent) bool { return e.Category != "Preparation" && e.Category != "Block" }
+
+// applyTurnover closes arrival and departure days whose own buffer would run
+// into another stay. Buffers may share nights with other buffers and with
+// blocks: one preparation serves both stays, and the house can be prepared
+// while it is closed. Existing site stays already hold their own buffers as
+// Preparation events, from their stored nights and guests. Under a
+// conditional policy a day is only closed when every stay allowed to start
+// there, down to the minimum nights and one guest, would get a buffer; longer
+// or larger stays are checked by stayConflicts when booked.
+func applyTurnover(resp *availabilityResponse, t TurnoverPolicy, events []icsEvent, from, to time.Time) {
+    if t.BeforeNights == 0 && t.AfterNights == 0 { return }
+    lo, hi := from.AddDate(0, 0, -t.BeforeNights), to.AddDate(0, 0, t.AfterNights)
+    var stays []icsEvent
+    for _, e := range expandEvents(events, lo, hi) {
+        if isStay(e) { stays = append(stays, e) }
+    }
+    taken := map[time.Time]bool{}
+    for _, r := range mergeRanges(stays, lo, hi) {
+        for d := r.Start; d.Before(r.End); d = d.AddDate(0, 0, 1) { taken[d] = true }
+    }
+    anyTaken := func(start time.Time, n int) bool {
+        for i := 0; i < n; i++ { if taken[start.AddDate(0, 0, i)] { return true } }
+        return false
+    }
+    for i := range resp.Nights {
+        if !t.applies(resp.Nights[i].MinNights, 1) { continue }
+        d := from.AddDate(0, 0, i)
+        if anyTaken(d.AddDate(0, 0, -t.BeforeNights), t.BeforeNights) { resp.Nights[i].ClosedToArrival = true }
+        if anyTaken(d, t.AfterNights) { resp.Nights[i].ClosedToDeparture = true }
+    }
+}
+
+// stayConflicts reports whether a new stay of [checkIn, checkOut) for guests
+// would overlap anything already on the calendar (stays, blocks or buffers),
+// or whether its own buffer, if the policy gives it one, would overlap a stay.
+func stayConflicts(t TurnoverPolicy, events []icsEvent, checkIn, checkOut time.Time, guests int) bool {
+    lo, hi := checkIn.AddDate(0, 0, -t.BeforeNights), checkOut.AddDate(0, 0, t.AfterNights)
+    overlaps := func(e icsEvent, start, end time.Time) bool { return e.Start.Before(end) && e.End.After(start) }
+    expanded := expandEvents(events, lo, hi)
+    for _, e := range expanded {
+        if overlaps(e, checkIn, checkOut) { return true }
+    }
+    for _, p := range preparationEvents(t, icsEvent{ Start: checkIn, End: checkOut }, guests) {
+        for _, e := range expanded {
+            if isStay(e) && overlaps(e, p.Start, p.End) { return true }
+        }
+    }
+    return false
+}
+
+func (s *Server) handleGetTurnover(w http.ResponseWriter, r *http.Request) {
+    if !s.requireOwner(w, r) { return }
+    t, err := s.turnoverPolicy(r.Context())
+    if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    jsonResp(w, 200, t)
+}
+
+func (s *Server) handlePutTurnover(w http.ResponseWriter, r *http.Request) {
+    if !s.requireOwner(w, r) { return }
+    var t TurnoverPolicy
+    if err := json.NewDecoder(r.Body).Decode(&t); err != nil { jsonResp(w, 400, map[string]string{"error":"invalid_input"}); return }
+    if t.BeforeNights < 0 || t.AfterNights < 0 || t.BeforeNights > 30 || t.AfterNights > 30 || t.MinStayNights < 0 || t.MinGuests < 0 { jsonResp(w, 400, map[string]string{"error":"invalid_input"}); return }
+    before, err := s.turnoverPolicy(r.Context())
+    if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    raw, _ := json.Marshal(t)
+    if _, err := s.pool.Exec(r.Context(), "INSERT INTO app_settings (key, value) VALUES ('turnover', $1) ON CONFLICT (key) DO UPDATE SET value=EXCLUDED.value, updated_at=now()", raw); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    s.invalidateAvailability()
+    s.audit(r, auditEntry{ Action: "settings.turnover", TargetType: "settings", TargetID: "turnover", Before: before, After: t })
+    jsonResp(w, 200, t)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestApplyTurnover(t *testing.T) {
+    d := func(s string) time.Time { return mustDate(t, s) }
+    site := icsEvent{ UID: "b1", Category: "Site", Start: d("2026-01-10"), End: d("2026-01-12") }
+    block := icsEvent{ UID: "k1", Category: "Block", Start: d("2026-01-10"), End: d("2026-01-12") }
+    tests := []struct {
+        name string
+        policy TurnoverPolicy
+        minNights int
+        events []icsEvent
+        wantArrival, wantDeparture string
+    }{
+        {"no buffer", TurnoverPolicy{}, 1, []icsEvent{site}, "", ""},
+        {"unconditional", TurnoverPolicy{ BeforeNights: 1, AfterNights: 1 }, 1, []icsEvent{site}, "11,12", "10,11"},
+        {"two nights before", TurnoverPolicy{ BeforeNights: 2 }, 1, []icsEvent{site}, "11,12,13", ""},
+        {"blocks don't close days", TurnoverPolicy{ BeforeNights: 1, AfterNights: 1 }, 1, []icsEvent{block}, "", ""},
+        {"buffers don't close days", TurnoverPolicy{ BeforeNights: 1, AfterNights: 1 }, 1, preparationEvents(TurnoverPolicy{ BeforeNights: 1, AfterNights: 1 }, site, 2), "", ""},
+        {"conditional, short stays allowed", TurnoverPolicy{ BeforeNights: 1, AfterNights: 1, MinStayNights: 3 }, 1, []icsEvent{site}, "", ""},
+        {"conditional, every stay qualifies", TurnoverPolicy{ BeforeNights: 1, AfterNights: 1, MinStayNights: 3 }, 3, []icsEvent{site}, "11,12", "10,11"},
+        {"conditional on one guest", TurnoverPolicy{ AfterNights: 1, MinGuests: 1 }, 1, []icsEvent{site}, "", "10,11"},
+        {"conditional on larger groups", TurnoverPolicy{ AfterNights: 1, MinGuests: 6 }, 1, []icsEvent{site}, "", ""},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            from, to := d("2026-01-01"), d("2026-01-20")
+            resp := &availabilityResponse{}
+            for day := from; day.Before(to); day = day.AddDate(0, 0, 1) { resp.Nights = append(resp.Nights, nightInfo{ Date: day.Format(dateLayout), MinNights: tt.minNights }) }
+            applyTurnover(resp, tt.policy, tt.events, from, to)
+            var arrival, departure []string
+            for _, n := range resp.Nights {
+                if n.ClosedToArrival { arrival = append(arrival, n.Date[8:]) }
+                if n.ClosedToDeparture { departure = append(departure, n.Date[8:]) }
+            }
+            if got := strings.Join(arrival, ","); got != tt.wantArrival { t.Errorf("closed to arrival %q, want %q", got, tt.wantArrival) }
+            if got := strings.Join(departure, ","); got != tt.wantDeparture { t.Errorf("closed to departure %q, want %q", got, tt.wantDeparture) }
+        })
+    }
+}
+
+func TestStayConflicts(t *testing.T) {
+    d := func(s string) time.Time { return mustDate(t, s) }
+    calendar := func(p TurnoverPolicy) []icsEvent {
+        site := icsEvent{ UID: "b1", Category: "Site", Start: d("2026-01-10"), End: d("2026-01-12") }
+        events := []icsEvent{
+            site,
+            { UID: "k1", Category: "Block", Start: d("2026-01-20"), End: d("2026-01-22") },
+            { UID: "a1", Category: "Airbnb", Start: d("2026-01-25"), End: d("2026-01-27") },
+            { UID: "k2", Category: "Block", Start: d("2026-02-02"), End: d("2026-02-03"), RRule: "FREQ=WEEKLY;COUNT=3" },
+        }
+        return append(events, preparationEvents(p, site, 2)...)
+    }
+    buffer := TurnoverPolicy{ BeforeNights: 1, AfterNights: 1 }
+    long := TurnoverPolicy{ BeforeNights: 1, AfterNights: 1, MinStayNights: 3 }
+    large := TurnoverPolicy{ BeforeNights: 1, AfterNights: 1, MinGuests: 4 }
+    tests := []struct {
+        name string
+        policy TurnoverPolicy
+        in, out string
+        guests int
+        want bool
+    }{
+        {"free", TurnoverPolicy{}, "2026-01-14", "2026-01-16", 2, false},
+        {"overlaps a stay", TurnoverPolicy{}, "2026-01-11", "2026-01-13", 2, true},
+        {"back to back without buffer", TurnoverPolicy{}, "2026-01-12", "2026-01-14", 2, false},
+        {"overlaps a block", TurnoverPolicy{}, "2026-01-21", "2026-01-23", 2, true},
+        {"overlaps a recurring block", TurnoverPolicy{}, "2026-02-15", "2026-02-17", 2, true},
+        {"between recurring blocks", TurnoverPolicy{}, "2026-02-10", "2026-02-16", 2, false},
+        {"overlaps an imported stay", TurnoverPolicy{}, "2026-01-26", "2026-01-28", 2, true},
+        {"overlaps a preparation night", buffer, "2026-01-12", "2026-01-14", 2, true},
+        {"own buffer on another buffer", buffer, "2026-01-13", "2026-01-15", 2, false},
+        {"own buffer on a block", buffer, "2026-01-18", "2026-01-20", 2, false},
+        {"own buffer on a stay", buffer, "2026-01-23", "2026-01-25", 2, true},
+        {"short stay needs no buffer", long, "2026-01-12", "2026-01-14", 2, false},
+        {"short stay next to imported stay", long, "2026-01-23", "2026-01-25", 2, false},
+        {"long stay needs its buffer", long, "2026-01-22", "2026-01-25", 2, true},
+        {"small group needs no buffer", large, "2026-01-23", "2026-01-25", 2, false},
+        {"large group needs its buffer", large, "2026-01-23", "2026-01-25", 4, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := stayConflicts(tt.policy, calendar(tt.policy), d(tt.in), d(tt.out), tt.guests); got != tt.want { t.Errorf("stayConflicts = %v, want %v", got, tt.want) }
+        })
+    }
+}
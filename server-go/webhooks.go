@@ -0,0 +1,295 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "io"
+    "log"
+    "net"
+    "net/http"
+    "net/url"
+    "strconv"
+    "syscall"
+    "time"
+    "github.com/gorilla/mux"
+    "github.com/jackc/pgx/v5"
+    "github.com/jackc/pgx/v5/pgxpool"
+)
+
+var webhookEventTypes = map[string]bool{
+    "booking.created": true,
+    "booking.approved": true,
+    "booking.rejected": true,
+    "block.created": true,
+    "block.updated": true,
+    "block.deleted": true,
+    "ical.synced": true,
+    "ical.sync_failed": true,
+}
+
+const webhookMaxAttempts = 8
+
+// WebhookDispatcher stores outbound events as deliveries and posts them to
+// subscribers from a background worker, retrying with exponential backoff.
+type WebhookDispatcher struct {
+    pool *pgxpool.Pool
+    client *http.Client
+    wake chan struct{}
+}
+
+func NewWebhookDispatcher(pool *pgxpool.Pool) *WebhookDispatcher {
+    return &WebhookDispatcher{ pool: pool, client: newWebhookClient(), wake: make(chan struct{}, 1) }
+}
+
+var errWebhookAddress = errors.New("webhook address not allowed")
+
+// webhookResolver is swapped out in tests.
+var webhookResolver interface{ LookupIPAddr(context.Context, string) ([]net.IPAddr, error) } = net.DefaultResolver
+
+// cgnat is the shared address space (RFC 6598), internal to carriers and
+// some cloud networks.
+var cgnat = &net.IPNet{ IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32) }
+
+// publicAddress reports whether ip is routable on the internet, so webhooks
+// can't reach this host, its network or cloud metadata endpoints.
+func publicAddress(ip net.IP) bool {
+    return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || cgnat.Contains(ip))
+}
+
+// newWebhookClient checks every address actually dialed, which covers DNS
+// changing after the webhook was saved and redirects to internal hosts.
+func newWebhookClient() *http.Client {
+    dialer := &net.Dialer{ Timeout: 5 * time.Second, Control: func(network, address string, c syscall.RawConn) error {
+        host, _, err := net.SplitHostPort(address)
+        if err != nil { return err }
+        if ip := net.ParseIP(host); ip == nil || !publicAddress(ip) { return errWebhookAddress }
+        return nil
+    } }
+    return &http.Client{ Timeout: 10 * time.Second, Transport: &http.Transport{ DialContext: dialer.DialContext, TLSHandshakeTimeout: 5 * time.Second, MaxIdleConns: 10, IdleConnTimeout: 90 * time.Second } }
+}
+
+// Enqueue records one delivery per active webhook subscribed to eventType.
+func (d *WebhookDispatcher) Enqueue(ctx context.Context, eventType string, data any) {
+    if d == nil { return }
+    payload, err := json.Marshal(map[string]any{"type": eventType, "created_at": time.Now().UTC().Format(time.RFC3339), "data": data})
+    if err != nil { log.Println("webhook payload:", err); return }
+    tag, err := d.pool.Exec(ctx, `INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload)
+SELECT id, gen_random_uuid(), $1, $2 FROM webhooks WHERE active AND (cardinality(event_types) = 0 OR $1 = ANY(event_types))`, eventType, payload)
+    if err != nil { log.Println("webhook enqueue:", err); return }
+    if tag.RowsAffected() > 0 {
+        select { case d.wake <- struct{}{}: default: }
+    }
+}
+
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+    t := time.NewTicker(15 * time.Second)
+    defer t.Stop()
+    for {
+        d.deliverDue(ctx)
+        select {
+        case <-ctx.Done(): return
+        case <-t.C:
+        case <-d.wake:
+        }
+    }
+}
+
+type webhookJob struct {
+    ID int64
+    EventID string
+    EventType string
+    Payload []byte
+    Attempts int
+    URL string
+    Secret string
+}
+
+// deliverDue claims due deliveries by pushing next_attempt_at forward, so
+// several instances can run the worker without sending twice.
+func (d *WebhookDispatcher) deliverDue(ctx context.Context) {
+    rows, err := d.pool.Query(ctx, `UPDATE webhook_deliveries wd SET next_attempt_at = now() + interval '2 minutes'
+FROM webhooks wh
+WHERE wd.webhook_id = wh.id AND wd.id IN (SELECT d.id FROM webhook_deliveries d JOIN webhooks a ON a.id = d.webhook_id AND a.active WHERE d.status='pending' AND d.next_attempt_at <= now() ORDER BY d.id LIMIT 20 FOR UPDATE OF d SKIP LOCKED)
+RETURNING wd.id, wd.event_id::text, wd.event_type, wd.payload::text, wd.attempts, wh.url, wh.secret`)
+    if err != nil { log.Println("webhook claim:", err); return }
+    var jobs []webhookJob
+    for rows.Next() {
+        var j webhookJob; var payload string
+        if err := rows.Scan(&j.ID, &j.EventID, &j.EventType, &payload, &j.Attempts, &j.URL, &j.Secret); err != nil { rows.Close(); log.Println("webhook claim:", err); return }
+        j.Payload = []byte(payload)
+        jobs = append(jobs, j)
+    }
+    rows.Close()
+    for _, j := range jobs { d.attempt(ctx, j) }
+}
+
+func signWebhook(secret, timestamp string, body []byte) string {
+    mac := hmac.New(sha256.New, []byte(secret))
+    mac.Write([]byte(timestamp + "."))
+    mac.Write(body)
+    return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff is the wait after the given number of attempts: 30s, 1m,
+// 2m, 4m ... capped at 6h.
+func webhookBackoff(attempts int) time.Duration {
+    if attempts < 1 { attempts = 1 }
+    if attempts > 16 { return 6 * time.Hour }
+    backoff := 30 * time.Second << (attempts - 1)
+    if backoff > 6*time.Hour { backoff = 6 * time.Hour }
+    return backoff
+}
+
+func (d *WebhookDispatcher) attempt(ctx context.Context, j webhookJob) {
+    ts := strconv.FormatInt(time.Now().Unix(), 10)
+    start := time.Now()
+    code := 0
+    var errMsg string
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, j.URL, bytes.NewReader(j.Payload))
+    if err == nil {
+        req.Header.Set("Content-Type", "application/json")
+        req.Header.Set("User-Agent", "ocean-haven-webhooks/1")
+        req.Header.Set("X-Webhook-Id", j.EventID)
+        req.Header.Set("X-Webhook-Event", j.EventType)
+        req.Header.Set("X-Webhook-Timestamp", ts)
+        req.Header.Set("X-Webhook-Signature", signWebhook(j.Secret, ts, j.Payload))
+        var resp *http.Response
+        resp, err = d.client.Do(req)
+        if err == nil {
+            code = resp.StatusCode
+            _, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+            resp.Body.Close()
+        }
+    }
+    if err != nil { errMsg = err.Error() } else if code >= 300 { errMsg = "unexpected status " + strconv.Itoa(code) }
+    attempts := j.Attempts + 1
+    status := "pending"
+    if errMsg == "" { status = "succeeded" } else if attempts >= webhookMaxAttempts { status = "failed" }
+    backoff := webhookBackoff(attempts)
+    if _, err := d.pool.Exec(ctx, "INSERT INTO webhook_delivery_attempts (delivery_id, response_code, error, duration_ms) VALUES ($1,$2,NULLIF($3,''),$4)", j.ID, code, errMsg, time.Since(start).Milliseconds()); err != nil { log.Println("webhook log:", err) }
+    if _, err := d.pool.Exec(ctx, "UPDATE webhook_deliveries SET status=$2, attempts=$3, last_response_code=$4, last_error=NULLIF($5,''), next_attempt_at=now() + $6::interval, updated_at=now() WHERE id=$1", j.ID, status, attempts, code, errMsg, strconv.FormatInt(int64(backoff/time.Second), 10)+" seconds"); err != nil { log.Println("webhook update:", err) }
+}
+
+// emitEvent publishes an event to webhook subscribers.
+func (s *Server) emitEvent(ctx context.Context, eventType string, data any) {
+    s.webhooks.Enqueue(ctx, eventType, data)
+}
+
+func newWebhookSecret() string {
+    b := make([]byte, 24)
+    _, _ = rand.Read(b)
+    return "whsec_" + hex.EncodeToString(b)
+}
+
+// validWebhookInput also resolves the host and refuses internal addresses.
+// The dialer checks again at delivery time.
+func validWebhookInput(ctx context.Context, u string, events []string) bool {
+    pu, err := url.Parse(u)
+    if err != nil || (pu.Scheme != "https" && pu.Scheme != "http") || pu.Hostname() == "" { return false }
+    for _, e := range events { if !webhookEventTypes[e] { return false } }
+    addrs, err := webhookResolver.LookupIPAddr(ctx, pu.Hostname())
+    if err != nil || len(addrs) == 0 { return false }
+    for _, a := range addrs { if !publicAddress(a.IP) { return false } }
+    return true
+}
+
+func (s *Server) handleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+    if !s.requireOwner(w, r) { return }
+    var body struct{ Url string; EventTypes []string }
+    _ = json.NewDecoder(r.Body).Decode(&body)
+    if !validWebhookInput(r.Context(), body.Url, body.EventTypes) { jsonResp(w, 400, map[string]string{"error":"invalid_input"}); return }
+    if body.EventTypes == nil { body.EventTypes = []string{} }
+    secret := newWebhookSecret()
+    var id int64
+    if err := s.pool.QueryRow(r.Context(), "INSERT INTO webhooks (url, secret, event_types, created_by) VALUES ($1,$2,$3,$4) RETURNING id", body.Url, secret, body.EventTypes, getClaims(r)["email"]).Scan(&id); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    s.audit(r, auditEntry{ Action: "webhook.create", TargetType: "webhook", TargetID: strconv.FormatInt(id, 10), After: map[string]any{"url": body.Url, "event_types": body.EventTypes} })
+    // The secret is only returned on creation.
+    jsonResp(w, 200, map[string]any{"id": id, "secret": secret})
+}
+
+func (s *Server) handleListWebhooks(w http.ResponseWriter, r *http.Request) {
+    if !s.requireOwner(w, r) { return }
+    rows, err := s.pool.Query(r.Context(), "SELECT id, url, event_types, active, created_at FROM webhooks ORDER BY id")
+    if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    type rec struct{ ID int64 `json:"id"`; Url string `json:"url"`; EventTypes []string `json:"event_types"`; Active bool `json:"active"`; CreatedAt time.Time `json:"created_at"` }
+    var out []rec
+    for rows.Next() { var a rec; if err := rows.Scan(&a.ID,&a.Url,&a.EventTypes,&a.Active,&a.CreatedAt); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return } ; out = append(out,a) }
+    if rows.Err() != nil { jsonResp(w, 500, map[string]string{"error": rows.Err().Error()}); return }
+    jsonResp(w, 200, map[string]any{"data": out})
+}
+
+func (s *Server) handleUpdateWebhook(w http.ResponseWriter, r *http.Request) {
+    if !s.requireOwner(w, r) { return }
+    var body struct{ Url string; EventTypes []string; Active bool }
+    _ = json.NewDecoder(r.Body).Decode(&body)
+    if !validWebhookInput(r.Context(), body.Url, body.EventTypes) { jsonResp(w, 400, map[string]string{"error":"invalid_input"}); return }
+    if body.EventTypes == nil { body.EventTypes = []string{} }
+    id := mux.Vars(r)["id"]
+    before, err := s.queryJSONRows(r.Context(), "SELECT url, event_types, active FROM webhooks WHERE id=$1", id)
+    if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    if len(before) == 0 { jsonResp(w, 404, map[string]string{"error":"not_found"}); return }
+    if _, err := s.pool.Exec(r.Context(), "UPDATE webhooks SET url=$2, event_types=$3, active=$4 WHERE id=$1", id, body.Url, body.EventTypes, body.Active); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    // Pausing a webhook drops its backlog rather than flooding it on resume.
+    if !body.Active {
+        if _, err := s.pool.Exec(r.Context(), "UPDATE webhook_deliveries SET status='cancelled', updated_at=now() WHERE webhook_id=$1 AND status='pending'", id); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    }
+    s.audit(r, auditEntry{ Action: "webhook.update", TargetType: "webhook", TargetID: id, Before: before[0], After: map[string]any{"url": body.Url, "event_types": body.EventTypes, "active": body.Active} })
+    jsonResp(w, 200, map[string]bool{"success": true})
+}
+
+func (s *Server) handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+    if !s.requireOwner(w, r) { return }
+    id := mux.Vars(r)["id"]
+    before, err := s.queryJSONRows(r.Context(), "DELETE FROM webhooks WHERE id=$1 RETURNING url, event_types, active", id)
+    if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    if len(before) > 0 { s.audit(r, auditEntry{ Action: "webhook.delete", TargetType: "webhook", TargetID: id, Before: before[0] }) }
+    jsonResp(w, 200, map[string]bool{"success": true})
+}
+
+func (s *Server) handleListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+    if !s.requireOwner(w, r) { return }
+    rows, err := s.pool.Query(r.Context(), `SELECT d.id, d.event_id::text, d.event_type, d.status, d.attempts, COALESCE(d.last_response_code,0), COALESCE(d.last_error,''), d.created_at,
+  COALESCE((SELECT json_agg(json_build_object('response_code', a.response_code, 'error', a.error, 'duration_ms', a.duration_ms, 'created_at', a.created_at) ORDER BY a.id) FROM webhook_delivery_attempts a WHERE a.delivery_id = d.id), '[]')::text
+FROM webhook_deliveries d WHERE d.webhook_id=$1 ORDER BY d.id DESC LIMIT 100`, mux.Vars(r)["id"])
+    if err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+    type rec struct{ ID int64 `json:"id"`; EventID string `json:"event_id"`; EventType string `json:"event_type"`; Status string `json:"status"`; Attempts int `json:"attempts"`; LastResponseCode int `json:"last_response_code"`; LastError string `json:"last_error"`; CreatedAt time.Time `json:"created_at"`; Log json.RawMessage `json:"log"` }
+    var out []rec
+    for rows.Next() {
+        var a rec; var logJSON string
+        if err := rows.Scan(&a.ID,&a.EventID,&a.EventType,&a.Status,&a.Attempts,&a.LastResponseCode,&a.LastError,&a.CreatedAt,&logJSON); err != nil { jsonResp(w, 500, map[string]string{"error": err.Error()}); return }
+        a.Log = json.RawMessage(logJSON)
+        out = append(out,a)
+    }
+    if rows.Err() != nil { jsonResp(w, 500, map[string]string{"error": rows.Err().Error()}); return }
+    jsonResp(w, 200, map[string]any{"data": out})
+}
+
+// handleReplayWebhookDelivery queues a fresh delivery of the same event, keeping
+// the original event id so receivers can deduplicate.
+func (s *Server) handleReplayWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+    if !s.requireOwner(w, r) { return }
+    v := mux.Vars(r)
+    var id int64
+    err := s.pool.QueryRow(r.Context(), "INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload) SELECT webhook_id, event_id, event_type, payload FROM webhook_deliveries WHERE id=$1 AND webhook_id=$2 RETURNING id", v["delivery_id"], v["id"]).Scan(&id)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) { jsonResp(w, 404, map[string]string{"error":"not_found"}); return }
+        jsonResp(w, 500, map[string]string{"error": err.Error()}); return
+    }
+    select { case s.webhooks.wake <- struct{}{}: default: }
+    s.audit(r, auditEntry{ Action: "webhook.replay", TargetType: "webhook_delivery", TargetID: v["delivery_id"], After: map[string]int64{"delivery_id": id} })
+    jsonResp(w, 200, map[string]any{"id": id})
+}
+
+// emitBookingEvent publishes a booking event without guest contact details.
+func (s *Server) emitBookingEvent(ctx context.Context, eventType, bookingID string) {
+    var status string; var ci, co time.Time; var guests int; var total float64
+    err := s.pool.QueryRow(ctx, "SELECT COALESCE(status,'requested'), check_in, check_out, COALESCE(number_of_guests,0), COALESCE(total_price,0)::float8 FROM bookings WHERE id=$1", bookingID).Scan(&status, &ci, &co, &guests, &total)
+    if err != nil { log.Println("booking event:", err); return }
+    s.emitEvent(ctx, eventType, map[string]any{"id": bookingID, "status": status, "check_in": ci.Format(dateLayout), "check_out": co.Format(dateLayout), "number_of_guests": guests, "total_price": total})
+}
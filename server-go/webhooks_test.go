@@ -0,0 +1,84 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+)
+
+func TestSignWebhook(t *testing.T) {
+    body := []byte(`{"type":"booking.created"}`)
+    tests := []struct{ secret, ts string; body []byte; want string }{
+        {"whsec_test", "1700000000", body, "sha256=9c1e6e2200925288bc7d6ea44aea27c9ef40fa88f4d3f54595139cdf4de1d784"},
+        {"other", "1700000000", body, "sha256=3a21ed36aad02be7de463e6bf71b5952a20f104da7a56b49e0a09d7c77aa4021"},
+        {"whsec_test", "1700000000", nil, "sha256=5967f3c560522fa40cf2876ebc3c3a08551dd6959aaade3b413460591895bdcc"},
+    }
+    for _, tt := range tests {
+        if got := signWebhook(tt.secret, tt.ts, tt.body); got != tt.want { t.Errorf("signWebhook(%q, %q) = %s, want %s", tt.secret, tt.ts, got, tt.want) }
+    }
+    if signWebhook("whsec_test", "1700000001", body) == tests[0].want { t.Error("signature doesn't cover the timestamp") }
+}
+
+func TestWebhookBackoff(t *testing.T) {
+    tests := []struct{ attempts int; want time.Duration }{
+        {1, 30 * time.Second},
+        {2, time.Minute},
+        {4, 4 * time.Minute},
+        {10, 256 * time.Minute},
+        {11, 6 * time.Hour},
+        {64, 6 * time.Hour},
+    }
+    for _, tt := range tests {
+        if got := webhookBackoff(tt.attempts); got != tt.want { t.Errorf("webhookBackoff(%d) = %v, want %v", tt.attempts, got, tt.want) }
+    }
+}
+
+type stubResolver map[string][]string
+
+func (r stubResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+    if ip := net.ParseIP(host); ip != nil { return []net.IPAddr{{IP: ip}}, nil }
+    var out []net.IPAddr
+    for _, a := range r[host] { out = append(out, net.IPAddr{IP: net.ParseIP(a)}) }
+    if len(out) == 0 { return nil, errors.New("no such host") }
+    return out, nil
+}
+
+func TestValidWebhookInput(t *testing.T) {
+    defer func(r interface{ LookupIPAddr(context.Context, string) ([]net.IPAddr, error) }) { webhookResolver = r }(webhookResolver)
+    webhookResolver = stubResolver{
+        "example.com": {"93.184.216.34"},
+        "localhost": {"127.0.0.1", "::1"},
+        "mixed.example.com": {"93.184.216.34", "10.0.0.5"},
+    }
+    tests := []struct{ url string; events []string; want bool }{
+        {"https://example.com/hook", nil, true},
+        {"https://example.com:8443/hook", []string{"booking.created", "block.deleted"}, true},
+        {"ftp://example.com/hook", nil, false},
+        {"https:///hook", nil, false},
+        {"https://example.com/hook", []string{"booking.deleted"}, false},
+        {"http://localhost:8080/hook", nil, false},
+        {"http://127.0.0.1/hook", nil, false},
+        {"http://[::1]/hook", nil, false},
+        {"http://10.1.2.3/hook", nil, false},
+        {"http://192.168.0.10/hook", nil, false},
+        {"http://169.254.169.254/latest/meta-data/", nil, false},
+        {"http://100.64.0.1/hook", nil, false},
+        {"http://0.0.0.0/hook", nil, false},
+        {"https://mixed.example.com/hook", nil, false},
+        {"https://unknown.example.com/hook", nil, false},
+    }
+    for _, tt := range tests {
+        if got := validWebhookInput(context.Background(), tt.url, tt.events); got != tt.want { t.Errorf("validWebhookInput(%q, %v) = %v, want %v", tt.url, tt.events, got, tt.want) }
+    }
+}
+
+func TestWebhookClientRefusesInternalAddresses(t *testing.T) {
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+    defer srv.Close()
+    _, err := newWebhookClient().Post(srv.URL, "application/json", nil)
+    if err == nil || !errors.Is(err, errWebhookAddress) { t.Fatalf("err = %v, want %v", err, errWebhookAddress) }
+}
@@ -1,4 +1,4 @@
-import { useState } from "react";
+import { useEffect, useState } from "react";
 import { Calendar } from "@/components/ui/calendar";
 import { Card, CardContent, CardDescription, CardHeader, CardTitle } from "@/components/ui/card";
 import { Button } from "@/components/ui/button";
@@ -7,7 +7,7 @@ import { Label } from "@/components/ui/label";
 import { toast } from "sonner";
 import { supabase } from "@/integrations/supabase/client";
 import { useNavigate } from "react-router-dom";
-import { differenceInDays } from "date-fns";
+import { differenceInDays, format } from "date-fns";
 
 const BASE_PRICE = 5000;
 const WEEKEND_PRICE = 6000;
@@ -48,6 +48,12 @@ function computePricing(checkIn?: Date, checkOut?: Date) {
   return { nights, weekdayNights, weekendNights, subtotal, discountPercent, discountAmount, total };
 }
 
+const API = "http://localhost:3005";
+
+type Availability = {
+  unavailable: { from: string; to: string }[];
+};
+
 const formatBRL = (n: number) => new Intl.NumberFormat("pt-BR", { style: "currency", currency: "BRL" }).format(n);
 
 export const BookingCalendar = () => {
@@ -59,6 +65,23 @@ export const BookingCalendar = () => {
   const [guestPhone, setGuestPhone] = useState("");
   const [numberOfGuests, setNumberOfGuests] = useState(1);
   const [loading, setLoading] = useState(false);
+  const [unavailable, setUnavailable] = useState<Set<string>>(new Set());
+
+  useEffect(() => {
+    fetch(`${API}/availability`)
+      .then((res) => (res.ok ? res.json() : null))
+      .then((data: Availability | null) => {
+        if (!data) return;
+        const nights = new Set<string>();
+        for (const r of data.unavailable) {
+          for (let d = new Date(`${r.from}T00:00:00`); format(d, "yyyy-MM-dd") < r.to; d.setDate(d.getDate() + 1)) {
+            nights.add(format(d, "yyyy-MM-dd"));
+          }
+        }
+        setUnavailable(nights);
+      })
+      .catch(() => {});
+  }, []);
 
   const pricing = computePricing(checkIn, checkOut);
 
@@ -90,8 +113,6 @@ export const BookingCalendar = () => {
       toast.error("Número de hóspedes inválido");
       return;
     }
-    const API = "http://localhost:3005";
-
     setLoading(true);
 
     try {
@@ -100,8 +121,8 @@ export const BookingCalendar = () => {
         method: "POST",
         headers: { "Content-Type": "application/json" },
         body: JSON.stringify({
-          CheckIn: checkIn.toISOString(),
-          CheckOut: checkOut.toISOString(),
+          CheckIn: format(checkIn, "yyyy-MM-dd"),
+          CheckOut: format(checkOut, "yyyy-MM-dd"),
           GuestName: guestName,
           GuestEmail: guestEmail,
           GuestPhone: guestPhone,
@@ -115,8 +136,8 @@ export const BookingCalendar = () => {
         created = {
           id: `srv-${Date.now()}`,
           status: "pending",
-          check_in: checkIn.toISOString().split("T")[0],
-          check_out: checkOut.toISOString().split("T")[0],
+          check_in: format(checkIn, "yyyy-MM-dd"),
+          check_out: format(checkOut, "yyyy-MM-dd"),
           number_of_guests: numberOfGuests,
           subtotal_price: pricing.subtotal,
           discount_amount: pricing.discountAmount,
@@ -127,8 +148,8 @@ export const BookingCalendar = () => {
       const bookingForView = created ?? {
         id: `temp-${Date.now()}`,
         status: "pending",
-        check_in: checkIn.toISOString().split("T")[0],
-        check_out: checkOut.toISOString().split("T")[0],
+        check_in: format(checkIn, "yyyy-MM-dd"),
+        check_out: format(checkOut, "yyyy-MM-dd"),
         number_of_guests: numberOfGuests,
         subtotal_price: pricing.subtotal,
         discount_amount: pricing.discountAmount,
@@ -166,7 +187,7 @@ export const BookingCalendar = () => {
                   setCheckIn(range?.from);
                   setCheckOut(range?.to);
                 }}
-                disabled={(date) => date < new Date()}
+                disabled={(date) => date < new Date() || unavailable.has(format(date, "yyyy-MM-dd"))}
                 showOutsideDays
                 numberOfMonths={2}
                 className="w-full"
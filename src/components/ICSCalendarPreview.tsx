@@ -94,6 +94,7 @@ export function ICSCalendarPreview() {
   const [availability, setAvailability] = React.useState<Record<string, boolean>>({});
   const [notes, setNotes] = React.useState<Record<string, string>>({});
   const [noteDraft, setNoteDraft] = React.useState('');
+  const [repeat, setRepeat] = React.useState('');
   const [flashRange, setFlashRange] = React.useState(false);
   const [bookings, setBookings] = React.useState<{ id: string; check_in: string; check_out: string; status?: string; guest_name?: string; guest_email?: string }[]>([]);
   const navigate = useNavigate();
@@ -103,7 +104,9 @@ export function ICSCalendarPreview() {
     const loadIcs = async () => {
       try {
         const API = 'http://localhost:3005';
-        const res = await fetch(`${API}/calendar/merged.ics?t=${Date.now()}`);
+        const token = localStorage.getItem('token');
+        if (!token) return;
+        const res = await fetch(`${API}/calendar/merged.ics?expand=true&t=${Date.now()}`, { headers: { Authorization: `Bearer ${token}` } });
         if (!res.ok) {
           return;
         }
@@ -333,7 +336,9 @@ export function ICSCalendarPreview() {
   const API = 'http://localhost:3005';
   const reloadCalendar = async () => {
     try {
-      const res = await fetch(`${API}/calendar/merged.ics?t=${Date.now()}`);
+      const token = localStorage.getItem('token');
+      if (!token) return;
+      const res = await fetch(`${API}/calendar/merged.ics?expand=true&t=${Date.now()}`, { headers: { Authorization: `Bearer ${token}` } });
       if (!res.ok) return;
       const text = await res.text();
       const events = parseICS(text);
@@ -349,7 +354,7 @@ export function ICSCalendarPreview() {
     const res = await fetch(`${API}/blocks`, {
       method: 'POST',
       headers: { 'Content-Type': 'application/json', Authorization: `Bearer ${token}` },
-      body: JSON.stringify({ From: from.toISOString(), To: addDays(to, 1).toISOString(), Note: note || '' }),
+      body: JSON.stringify({ From: format(from, 'yyyy-MM-dd'), To: format(addDays(to, 1), 'yyyy-MM-dd'), Note: note || '', RRule: repeat }),
     });
     if (!res.ok) {
       if (res.status === 403) { toast.error('Você precisa ser proprietário para bloquear'); } else { toast.error('Erro ao bloquear período'); }
@@ -365,7 +370,7 @@ export function ICSCalendarPreview() {
     const res = await fetch(`${API}/blocks/unblock`, {
       method: 'POST',
       headers: { 'Content-Type': 'application/json', Authorization: `Bearer ${token}` },
-      body: JSON.stringify({ From: from.toISOString(), To: addDays(to, 1).toISOString() }),
+      body: JSON.stringify({ From: format(from, 'yyyy-MM-dd'), To: format(addDays(to, 1), 'yyyy-MM-dd') }),
     });
     if (!res.ok) {
       if (res.status === 403) { toast.error('Você precisa ser proprietário para desbloquear'); } else { toast.error('Erro ao desbloquear período'); }
@@ -552,6 +557,20 @@ export function ICSCalendarPreview() {
                   />
                 </div>
 
+                <div className='flex items-center justify-between gap-2'>
+                  <span className='text-sm'>Repetir</span>
+                  <select
+                    className='rounded-md border border-input bg-background px-2 py-1 text-sm'
+                    value={repeat}
+                    onChange={(e) => setRepeat(e.target.value)}
+                  >
+                    <option value=''>Não repetir</option>
+                    <option value='FREQ=WEEKLY'>Toda semana</option>
+                    <option value='FREQ=MONTHLY'>Todo mês</option>
+                    <option value='FREQ=YEARLY'>Todo ano</option>
+                  </select>
+                </div>
+
                 <Button
                   variant='gradient'
                   size='sm'
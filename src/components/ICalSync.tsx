@@ -9,21 +9,102 @@ import { Calendar, Trash2, RefreshCw, Copy, Hotel, Globe } from "lucide-react";
 import { format } from "date-fns";
 import { ptBR } from "date-fns/locale";
 
-interface CalendarSync { id: number; platform: string; url: string; created_at?: string }
+interface ImportRange { from: string; to: string; note: string; past: boolean; duplicate: boolean; conflicts: { booking_id: string; guest_name: string }[] }
+
+interface CalendarSync { id: number; platform: string; url: string; export_path?: string; export_private?: boolean; created_at?: string }
+
+const API = "http://localhost:3005";
 
 export function ICalSync() {
   const [syncs, setSyncs] = useState<CalendarSync[]>([]);
   const [loading, setLoading] = useState(true);
   const [newPlatform, setNewPlatform] = useState("");
   const [newUrl, setNewUrl] = useState("");
+  const [ownerFeedPath, setOwnerFeedPath] = useState("");
+  const [importFile, setImportFile] = useState<File | null>(null);
+  const [importPreview, setImportPreview] = useState<ImportRange[]>([]);
+  const [appPassword, setAppPassword] = useState<{ username: string; password: string } | null>(null);
 
   useEffect(() => {
     loadSyncs();
+    loadOwnerFeed();
   }, []);
 
+  const loadOwnerFeed = async () => {
+    const token = localStorage.getItem("token");
+    if (!token) return;
+    const res = await fetch(`${API}/calendar/owner-feed`, { headers: { Authorization: `Bearer ${token}` } });
+    if (res.ok) { const j = await res.json(); setOwnerFeedPath(j.feed_path || ""); }
+  };
+
+  const rotateOwnerFeed = async () => {
+    const token = localStorage.getItem("token");
+    if (!token) { toast.error("Faça login"); return; }
+    const res = await fetch(`${API}/calendar/owner-feed/rotate`, { method: "POST", headers: { Authorization: `Bearer ${token}` } });
+    if (!res.ok) { toast.error("Erro ao gerar novo link"); return; }
+    const j = await res.json();
+    setOwnerFeedPath(j.feed_path || "");
+    toast.success("Novo link gerado; o anterior deixou de funcionar");
+  };
+
+  const importBlocks = async (confirm: boolean) => {
+    const token = localStorage.getItem("token");
+    if (!token) { toast.error("Faça login"); return; }
+    if (!importFile) return;
+    const form = new FormData();
+    form.append("file", importFile);
+    const res = await fetch(`${API}/blocks/import${confirm ? "?confirm=true" : ""}`, {
+      method: "POST",
+      headers: { Authorization: `Bearer ${token}` },
+      body: form,
+    });
+    if (!res.ok) { toast.error("Arquivo .ics inválido"); return; }
+    const j = await res.json();
+    if (confirm) {
+      toast.success(`${j.created} bloqueio(s) importado(s)`);
+      setImportFile(null);
+      setImportPreview([]);
+    } else {
+      setImportPreview(j.data || []);
+    }
+  };
+
+  const createAppPassword = async () => {
+    const token = localStorage.getItem("token");
+    if (!token) { toast.error("Faça login"); return; }
+    const res = await fetch(`${API}/app-passwords`, {
+      method: "POST",
+      headers: { "Content-Type": "application/json", Authorization: `Bearer ${token}` },
+      body: JSON.stringify({ name: `Agenda ${format(new Date(), "dd/MM/yyyy HH:mm")}` }),
+    });
+    if (!res.ok) { toast.error("Erro ao gerar senha de app"); return; }
+    const j = await res.json();
+    setAppPassword({ username: j.username, password: j.password });
+  };
+
+  const rotateExport = async (id: number) => {
+    const token = localStorage.getItem("token");
+    if (!token) { toast.error("Faça login"); return; }
+    const res = await fetch(`${API}/ical/${id}/export/rotate`, { method: "POST", headers: { Authorization: `Bearer ${token}` } });
+    if (!res.ok) { toast.error("Erro ao gerar novo link"); return; }
+    toast.success("Novo link gerado; atualize-o na plataforma");
+    loadSyncs();
+  };
+
+  const setExportPrivate = async (id: number, isPrivate: boolean) => {
+    const token = localStorage.getItem("token");
+    if (!token) { toast.error("Faça login"); return; }
+    const res = await fetch(`${API}/ical/${id}/export`, {
+      method: "PUT",
+      headers: { "Content-Type": "application/json", Authorization: `Bearer ${token}` },
+      body: JSON.stringify({ private: isPrivate }),
+    });
+    if (!res.ok) { toast.error("Erro ao salvar"); return; }
+    loadSyncs();
+  };
+
   const loadSyncs = async () => {
     const token = localStorage.getItem("token");
-    const API = "http://localhost:3005";
     const res = await fetch(`${API}/ical`, { headers: token ? { Authorization: `Bearer ${token}` } : {} });
     if (!res.ok) { toast.error("Erro ao carregar sincronizações"); }
     else { const j = await res.json(); setSyncs(j.data || []); }
@@ -37,7 +118,6 @@ export function ICalSync() {
     }
     const token = localStorage.getItem("token");
     if (!token) { toast.error("Faça login"); return; }
-    const API = "http://localhost:3005";
     const res = await fetch(`${API}/ical`, {
       method: "POST",
       headers: { "Content-Type": "application/json", Authorization: `Bearer ${token}` },
@@ -53,7 +133,6 @@ export function ICalSync() {
   const removeSync = async (id: number) => {
     const token = localStorage.getItem("token");
     if (!token) { toast.error("Faça login"); return; }
-    const API = "http://localhost:3005";
     const res = await fetch(`${API}/ical/${id}`, { method: "DELETE", headers: { Authorization: `Bearer ${token}` } });
     if (!res.ok) { toast.error("Erro ao remover"); return; }
     toast.success("Sincronização removida");
@@ -148,25 +227,83 @@ export function ICalSync() {
                 <CardTitle className="flex items-center gap-2">
                   <Globe className="h-4 w-4" /> Nosso iCal URL
                 </CardTitle>
-                <CardDescription>Compartilhe seu calendário público</CardDescription>
+                <CardDescription>Calendário detalhado para o seu app de agenda. Não compartilhe este link.</CardDescription>
               </CardHeader>
               <CardContent className="space-y-2">
                 <div className="flex gap-2">
-                  <Input readOnly value={`http://localhost:3005/calendar/merged.ics`} />
+                  <Input readOnly value={ownerFeedPath ? `${API}${ownerFeedPath}` : ""} />
                   <Button
                     variant="outline"
                     size="icon"
-                    onClick={() => navigator.clipboard.writeText(`http://localhost:3005/calendar/merged.ics`)}
+                    onClick={() => navigator.clipboard.writeText(`${API}${ownerFeedPath}`)}
+                    disabled={!ownerFeedPath}
                     title="Copiar URL"
                   >
                     <Copy className="h-4 w-4" />
                   </Button>
+                  <Button variant="outline" size="icon" onClick={rotateOwnerFeed} title="Gerar novo link">
+                    <RefreshCw className="h-4 w-4" />
+                  </Button>
+                </div>
+                <div className="pt-2 space-y-2">
+                  <p className="text-sm text-muted-foreground">
+                    Para criar e editar bloqueios pelo app de agenda, adicione uma conta CalDAV em {`${API}/caldav/`}.
+                  </p>
+                  {appPassword ? (
+                    <div className="text-sm space-y-1">
+                      <p>Usuário: <span className="font-mono">{appPassword.username}</span></p>
+                      <p>Senha: <span className="font-mono">{appPassword.password}</span></p>
+                      <p className="text-muted-foreground">Esta senha não será mostrada novamente.</p>
+                    </div>
+                  ) : (
+                    <Button variant="outline" size="sm" onClick={createAppPassword}>
+                      Gerar senha de app
+                    </Button>
+                  )}
                 </div>
               </CardContent>
             </Card>
           </div>
         </div>
 
+        <Card className="bg-background/50 border-border">
+          <CardHeader>
+            <CardTitle>Importar arquivo .ics</CardTitle>
+            <CardDescription>Carrega um calendário uma única vez como bloqueios manuais</CardDescription>
+          </CardHeader>
+          <CardContent className="space-y-3">
+            <div className="flex gap-2">
+              <Input
+                type="file"
+                accept=".ics,text/calendar"
+                onChange={(e) => { setImportFile(e.target.files?.[0] ?? null); setImportPreview([]); }}
+              />
+              <Button variant="outline" onClick={() => importBlocks(false)} disabled={!importFile}>
+                Pré-visualizar
+              </Button>
+            </div>
+            {importPreview.length > 0 && (
+              <div className="space-y-2">
+                {importPreview.map((r, i) => (
+                  <div key={i} className="flex items-center justify-between text-sm p-2 rounded border border-border">
+                    <span>{r.from} → {r.to} · {r.note || "Bloqueio"}</span>
+                    <span className="flex gap-1">
+                      {r.past && <Badge variant="secondary">Passado</Badge>}
+                      {r.duplicate && <Badge variant="secondary">Já bloqueado</Badge>}
+                      {r.conflicts.length > 0 && (
+                        <Badge variant="destructive">Conflito: {r.conflicts.map((c) => c.guest_name).join(", ")}</Badge>
+                      )}
+                    </span>
+                  </div>
+                ))}
+                <Button variant="gradient" onClick={() => importBlocks(true)}>
+                  Confirmar importação
+                </Button>
+              </div>
+            )}
+          </CardContent>
+        </Card>
+
         {/* Existing syncs */}
         <div className="space-y-3">
           {loading ? (
@@ -189,6 +326,32 @@ export function ICalSync() {
                   <p className="text-xs text-muted-foreground truncate max-w-md">
                     {sync.url}
                   </p>
+                  {sync.export_path && (
+                    <div className="flex items-center gap-2 mt-2">
+                      <p className="text-xs text-muted-foreground truncate max-w-md">
+                        Exportar para {sync.platform}: {`${API}${sync.export_path}`}
+                      </p>
+                      <Button
+                        size="icon"
+                        variant="ghost"
+                        onClick={() => navigator.clipboard.writeText(`${API}${sync.export_path}`)}
+                        title="Copiar URL de exportação"
+                      >
+                        <Copy className="h-3 w-3" />
+                      </Button>
+                      <Button size="icon" variant="ghost" onClick={() => rotateExport(sync.id)} title="Gerar novo link de exportação">
+                        <RefreshCw className="h-3 w-3" />
+                      </Button>
+                      <label className="flex items-center gap-1 text-xs text-muted-foreground">
+                        <input
+                          type="checkbox"
+                          checked={sync.export_private ?? true}
+                          onChange={(e) => setExportPrivate(sync.id, e.target.checked)}
+                        />
+                        Somente "Unavailable"
+                      </label>
+                    </div>
+                  )}
                   {sync.created_at && (
                     <p className="text-xs text-muted-foreground mt-1">
                       Adicionado:{" "}